@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/spf13/cobra"
+)
+
+var doctorSendTo string
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorSendTo, "send-test", "", "send a test e-mail to an address")
+
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// Checks are skipped once a check they depend on has failed.
+type doctorReport struct {
+	w      *tabwriter.Writer
+	failed bool
+}
+
+func (r *doctorReport) check(name string, f func() (string, error)) bool {
+	detail, err := f()
+	if err != nil {
+		r.failed = true
+		fmt.Fprintf(r.w, "FAIL\t%s\t%s\n", name, err)
+		return false
+	}
+	fmt.Fprintf(r.w, "PASS\t%s\t%s\n", name, detail)
+	return true
+}
+
+func (r *doctorReport) skip(name string, reason string) {
+	fmt.Fprintf(r.w, "SKIP\t%s\t%s\n", name, reason)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Args:  cobra.NoArgs,
+	Short: "Check the configuration, and the connections to the database, IMAP and SMTP servers",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		report := &doctorReport{w: tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)}
+		defer func() {
+			report.w.Flush()
+			if report.failed {
+				os.Exit(1)
+			}
+		}()
+
+		if !report.check("config", func() (string, error) {
+			if missing := config.MissingKeys(); len(missing) > 0 {
+				return "", fmt.Errorf("missing keys: %s", strings.Join(missing, ", "))
+			}
+			return "", nil
+		}) {
+			report.skip("everything else", "config is incomplete")
+			return
+		}
+		conf := config.GetConfig()
+
+		report.check("timezone", func() (string, error) {
+			_, err := time.LoadLocation(conf.Timezone)
+			return conf.Timezone, err
+		})
+
+		if report.check("database", func() (string, error) {
+			if db.IsSQLite(conf.Database.URL) {
+				sqlDB, err := db.OpenSQLite(ctx, conf.Database.URL)
+				if err != nil {
+					return "", err
+				}
+				defer sqlDB.Close()
+				return "sqlite", nil
+			}
+			pool, err := db.NewPool(ctx, conf.Database.URL)
+			if err != nil {
+				return "", err
+			}
+			defer pool.Close()
+			return "", pool.Ping(ctx)
+		}) {
+			report.check("migrations", func() (string, error) {
+				current, target, err := db.Status(ctx, conf.Database.URL)
+				if err != nil {
+					return "", err
+				}
+				if current != target {
+					return "", fmt.Errorf("schema is at version %d, expected %d (run mxremind migrate)", current, target)
+				}
+				return fmt.Sprintf("version %d", current), nil
+			})
+		} else {
+			report.skip("migrations", "database check failed")
+		}
+
+		var imapClient *client.Client
+		if report.check("imap", func() (string, error) {
+			var err error
+			imapClient, err = mail.ConnectImap(conf.IMAP)
+			return fmt.Sprintf("%s@%s:%d", conf.IMAP.Address, conf.IMAP.Host, conf.IMAP.Port), err
+		}) {
+			defer imapClient.Logout()
+			mailboxes := map[string]string{
+				"mailbox.in":        conf.Mailbox.In,
+				"mailbox.processed": conf.Mailbox.Processed,
+			}
+			if conf.Mailbox.Rejected != "" {
+				mailboxes["mailbox.rejected"] = conf.Mailbox.Rejected
+			}
+			for _, key := range []string{"mailbox.in", "mailbox.processed", "mailbox.rejected"} {
+				name, ok := mailboxes[key]
+				if !ok {
+					continue
+				}
+				report.check(key, func() (string, error) {
+					status, err := imapClient.Status(name, []imap.StatusItem{imap.StatusMessages})
+					if err != nil {
+						return "", fmt.Errorf("%q: %w", name, err)
+					}
+					return fmt.Sprintf("%q (%d messages)", name, status.Messages), nil
+				})
+			}
+		} else {
+			report.skip("mailboxes", "imap check failed")
+		}
+
+		var smtpClient *mail.SmtpClient
+		if report.check("smtp", func() (string, error) {
+			var err error
+			smtpClient, err = mail.ConnectSmtp(conf.SMTP)
+			return fmt.Sprintf("%s@%s:%d", conf.SMTP.Address, conf.SMTP.Host, conf.SMTP.Port), err
+		}) {
+			defer smtpClient.Quit()
+			if doctorSendTo != "" {
+				report.check("test e-mail", func() (string, error) {
+					err := smtpClient.Send(doctorSendTo, "MxRemind test", "This is a test e-mail from mxremind doctor.")
+					return "sent to " + doctorSendTo, err
+				})
+			}
+		} else if doctorSendTo != "" {
+			report.skip("test e-mail", "smtp check failed")
+		}
+	}}
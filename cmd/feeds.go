@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/web"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	feedsCmd.AddCommand(feedsCreateCmd)
+	feedsCmd.AddCommand(feedsListCmd)
+	feedsCmd.AddCommand(feedsRevokeCmd)
+	rootCmd.AddCommand(feedsCmd)
+}
+
+var feedsCmd = &cobra.Command{
+	Use:   "feeds",
+	Short: "Manage the calendar feeds of users",
+}
+
+var feedsCreateCmd = &cobra.Command{
+	Use:   "create <email>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Create a calendar feed for a user, and print its URL",
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := config.GetHTTPConfig("http")
+		withTx(func(ctx context.Context, tx pgx.Tx) error {
+			token, err := (&web.FeedDAO{Tx: tx, Context: ctx}).Create(args[0])
+			if err == nil {
+				fmt.Println(conf.BaseURL + web.FeedPath(token))
+			}
+			return err
+		})
+	}}
+
+var feedsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List calendar feeds",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTx(func(ctx context.Context, tx pgx.Tx) error {
+			feeds, err := (&web.FeedDAO{Tx: tx, Context: ctx}).List()
+			for _, f := range feeds {
+				fmt.Printf("%s\t%s\t%s\n", f.Id(), f.Email, f.CreatedAt.Format("2006-01-02"))
+			}
+			return err
+		})
+	}}
+
+var feedsRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Revoke a calendar feed",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTx(func(ctx context.Context, tx pgx.Tx) error {
+			return (&web.FeedDAO{Tx: tx, Context: ctx}).Revoke(args[0])
+		})
+	}}
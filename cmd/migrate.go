@@ -2,23 +2,143 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
+	"text/tabwriter"
 
 	"github.com/jbchouinard/mxremind/pkg/config"
 	"github.com/jbchouinard/mxremind/pkg/db"
 	"github.com/spf13/cobra"
 )
 
+var migrateTo int32
+
 func init() {
+	migrateUpCmd.Flags().Int32Var(&migrateTo, "to", -1, "version to migrate up to (default: the latest)")
+	migrateDownCmd.Flags().Int32Var(&migrateTo, "to", -1, "version to migrate down to")
+	migrateDownCmd.MarkFlagRequired("to")
+
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateRedoCmd)
 	rootCmd.AddCommand(migrateCmd)
 }
 
+func withMigrations(f func(ctx context.Context, m *db.Migrations) error) {
+	ctx := context.Background()
+	conf := config.GetDatabaseConfig("database")
+	m, err := db.OpenMigrations(ctx, conf.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer m.Close()
+	if err := f(ctx, m); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func migrateFromTo(ctx context.Context, m *db.Migrations, current int32, version int32) error {
+	if err := m.MigrateTo(ctx, version); err != nil {
+		return err
+	}
+	fmt.Printf("migrated from version %d to %d\n", current, version)
+	return nil
+}
+
 var migrateCmd = &cobra.Command{
 	Use:   "migrate",
-	Short: "Apply database migrations",
+	Short: "Apply database migrations, up to the latest version without a subcommand",
 	Run: func(cmd *cobra.Command, args []string) {
 		conf := config.GetDatabaseConfig("database")
 		if err := db.Migrate(context.Background(), conf.URL); err != nil {
 			log.Fatal(err)
 		}
 	}}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Args:  cobra.NoArgs,
+	Short: "Show the applied and pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		withMigrations(func(ctx context.Context, m *db.Migrations) error {
+			current, err := m.Current(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("current version: %d\ntarget version: %d\n\n", current, m.Target())
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+			for _, migration := range m.List() {
+				status := "pending"
+				if migration.Sequence <= current {
+					status = "applied"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", migration.Sequence, migration.Name, status)
+			}
+			return w.Flush()
+		})
+	}}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up [--to <version>]",
+	Args:  cobra.NoArgs,
+	Short: "Apply migrations up to a version",
+	Run: func(cmd *cobra.Command, args []string) {
+		withMigrations(func(ctx context.Context, m *db.Migrations) error {
+			current, err := m.Current(ctx)
+			if err != nil {
+				return err
+			}
+			version := migrateTo
+			if version < 0 {
+				version = m.Target()
+			}
+			if version < current {
+				return fmt.Errorf("version %d is below the current version %d, use migrate down", version, current)
+			}
+			return migrateFromTo(ctx, m, current, version)
+		})
+	}}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down --to <version>",
+	Args:  cobra.NoArgs,
+	Short: "Revert migrations down to a version",
+	Run: func(cmd *cobra.Command, args []string) {
+		withMigrations(func(ctx context.Context, m *db.Migrations) error {
+			current, err := m.Current(ctx)
+			if err != nil {
+				return err
+			}
+			if migrateTo < 0 || migrateTo > current {
+				return fmt.Errorf("version %d is not between 0 and the current version %d", migrateTo, current)
+			}
+			return migrateFromTo(ctx, m, current, migrateTo)
+		})
+	}}
+
+var migrateRedoCmd = &cobra.Command{
+	Use:   "redo",
+	Args:  cobra.NoArgs,
+	Short: "Revert and apply again the latest applied migration",
+	Run: func(cmd *cobra.Command, args []string) {
+		withMigrations(func(ctx context.Context, m *db.Migrations) error {
+			current, err := m.Current(ctx)
+			if err != nil {
+				return err
+			}
+			if current == 0 {
+				return fmt.Errorf("no migration is applied")
+			}
+			if err := m.MigrateTo(ctx, current-1); err != nil {
+				return err
+			}
+			if err := m.MigrateTo(ctx, current); err != nil {
+				return err
+			}
+			fmt.Printf("reverted and applied version %d again\n", current)
+			return nil
+		})
+	}}
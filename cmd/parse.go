@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var parseTimezone string
+var parseNow string
+var parseFrom string
+
+func init() {
+	parseCmd.Flags().StringVar(&parseTimezone, "tz", "", "timezone of the sender (default: the configured timezone)")
+	parseCmd.Flags().StringVar(&parseNow, "now", "", "time the mail is received at (default: now)")
+	parseCmd.Flags().StringVar(&parseFrom, "from", "sender@example.com", "sender of the mail")
+
+	rootCmd.AddCommand(parseCmd)
+}
+
+var parseCmd = &cobra.Command{
+	Use:   "parse <subject>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Show the reminder a mail subject would set, without saving it",
+	Run: func(cmd *cobra.Command, args []string) {
+		if parseTimezone != "" {
+			viper.Set("timezone", parseTimezone)
+		}
+		loc := location()
+		now := time.Now().In(loc)
+		if parseNow != "" {
+			now = parseTimeFlag("now", parseNow, loc)
+		}
+		allow, err := reminder.NewAddressList(config.GetRecipientsConfig("recipients").Allow)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid recipients.allow")
+		}
+		policy := &reminder.RecipientPolicy{Mailbox: config.MailboxAddress("mailbox"), Allow: allow}
+		m := &mail.Mail{From: parseFrom, Subject: args[0], Location: loc}
+		rem, err := reminder.ReminderFromMail(m, policy, now)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("cannot parse %q", args[0])
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "format:\t%s\n", reminder.SpecFormat(args[0]))
+		writeReminder(w, rem, loc)
+		w.Flush()
+	}}
+
+func writeReminder(w io.Writer, rem *reminder.Reminder, loc *time.Location) {
+	fmt.Fprintf(w, "due:\t%s (%s)\n", rem.DueTime.In(loc).Format("Monday 2006-01-02 15:04 MST"), rem.DueTime.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "content:\t%s\n", rem.Content)
+	fmt.Fprintf(w, "recipient:\t%s\n", rem.Recipient)
+	if len(rem.Recipients) > 0 {
+		fmt.Fprintf(w, "recipients:\t%s\n", strings.Join(rem.Recipients, ", "))
+	}
+	if len(rem.Tags) > 0 {
+		fmt.Fprintf(w, "tags:\t%s\n", strings.Join(rem.Tags, ", "))
+	}
+	if rem.Priority != "" {
+		fmt.Fprintf(w, "priority:\t%s\n", rem.Priority)
+	}
+	if rem.CanEscalate() {
+		fmt.Fprintf(w, "escalation:\t%s after %s\n", rem.EscalateTo, rem.EscalateAfter)
+	}
+}
+
+func previewMail(w io.Writer, m *mail.Mail, policy *reminder.Policy) {
+	fmt.Fprintf(w, "%s\t%q\n", m.From, m.Subject)
+	if err := policy.Check(m); err != nil {
+		fmt.Fprintf(w, "  rejected:\t%s\n", err)
+		return
+	}
+	commands, err := reminder.CommandsFromMail(m, policy.Recipients)
+	if err != nil {
+		fmt.Fprintf(w, "  error:\t%s\n", err)
+		return
+	}
+	for _, cmd := range commands {
+		switch c := cmd.(type) {
+		case *reminder.NewReminder:
+			format := reminder.SpecFormat(m.Subject)
+			if len(m.Events) > 0 {
+				format = "calendar invite"
+			}
+			fmt.Fprintf(w, "  new reminder:\t%s\n", format)
+			writeReminder(&indentWriter{w}, c.Reminder, m.Location)
+		case *reminder.Acknowledgement:
+			fmt.Fprintf(w, "  acknowledge:\t%s\n", c.ReminderId)
+		case *reminder.SetRoute:
+			fmt.Fprintf(w, "  set route:\t%s %s\n", c.Route.Match, strings.Join(c.Route.Channels, ","))
+		case *reminder.DeleteRoute:
+			fmt.Fprintf(w, "  delete route:\t%s\n", c.Match)
+		default:
+			fmt.Fprintf(w, "  %T\n", cmd)
+		}
+	}
+}
+
+type indentWriter struct {
+	w io.Writer
+}
+
+func (iw *indentWriter) Write(p []byte) (int, error) {
+	if _, err := iw.w.Write([]byte("    ")); err != nil {
+		return 0, err
+	}
+	return iw.w.Write(p)
+}
@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/api"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var remindersFilter reminder.Filter
+var remindersDueAfter string
+var remindersDueBefore string
+var remindersJSON bool
+var reminderTo string
+var reminderAt string
+var reminderContent string
+var purgeBefore string
+var purgeDryRun bool
+
+func init() {
+	remindersLsCmd.Flags().StringVar(&remindersFilter.Recipient, "recipient", "", "only list reminders of a recipient")
+	remindersLsCmd.Flags().StringVar(&remindersFilter.Status, "status", "", "only list reminders with a status (pending, sent or cancelled)")
+	remindersLsCmd.Flags().StringVar(&remindersDueAfter, "due-after", "", "only list reminders due at or after a time")
+	remindersLsCmd.Flags().StringVar(&remindersDueBefore, "due-before", "", "only list reminders due before a time")
+	remindersLsCmd.Flags().IntVar(&remindersFilter.Limit, "limit", 0, "maximum number of reminders to list")
+	remindersLsCmd.Flags().BoolVar(&remindersJSON, "json", false, "output in JSON format")
+	remindersShowCmd.Flags().BoolVar(&remindersJSON, "json", false, "output in JSON format")
+	remindersAddCmd.Flags().StringVar(&reminderTo, "to", "", "recipient of the reminder")
+	remindersAddCmd.Flags().StringVar(&reminderAt, "at", "", "due time of the reminder")
+	remindersAddCmd.Flags().StringVar(&reminderContent, "content", "", "content of the reminder")
+	remindersAddCmd.MarkFlagRequired("to")
+	remindersAddCmd.MarkFlagRequired("at")
+	remindersAddCmd.MarkFlagRequired("content")
+	remindersRescheduleCmd.Flags().StringVar(&reminderAt, "at", "", "new due time of the reminder")
+	remindersRescheduleCmd.MarkFlagRequired("at")
+	remindersPurgeCmd.Flags().StringVar(&purgeBefore, "before", "", "purge reminders due before a time")
+	remindersPurgeCmd.Flags().BoolVar(&purgeDryRun, "dry-run", false, "only count the reminders that would be purged")
+	remindersPurgeCmd.MarkFlagRequired("before")
+	remindersCmd.AddCommand(remindersLsCmd)
+	remindersCmd.AddCommand(remindersShowCmd)
+	remindersCmd.AddCommand(remindersAddCmd)
+	remindersCmd.AddCommand(remindersCancelCmd)
+	remindersCmd.AddCommand(remindersRescheduleCmd)
+	remindersCmd.AddCommand(remindersRequeueCmd)
+	remindersCmd.AddCommand(remindersPurgeCmd)
+	rootCmd.AddCommand(remindersCmd)
+}
+
+var remindersCmd = &cobra.Command{
+	Use:   "reminders",
+	Short: "Manage reminders",
+	Long: `Manage reminders.
+
+Times are in the configured timezone, formatted as YYYY-MM-DD, YYYY-MM-DD HH:MM,
+or RFC 3339 (2006-01-02T15:04:05-05:00).`,
+}
+
+var remindersLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List reminders",
+	Run: func(cmd *cobra.Command, args []string) {
+		loc := location()
+		remindersFilter.DueAfter = parseTimeFlag("due-after", remindersDueAfter, loc)
+		remindersFilter.DueBefore = parseTimeFlag("due-before", remindersDueBefore, loc)
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			reminders, err := dao.QueryFilter(&remindersFilter)
+			if err != nil {
+				return err
+			}
+			if remindersJSON {
+				list := make([]*api.ReminderJSON, 0, len(reminders))
+				for _, rem := range reminders {
+					list = append(list, api.NewReminderJSON(rem))
+				}
+				return printJSON(list)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tDUE\tSTATUS\tRECIPIENT\tCONTENT")
+			for _, rem := range reminders {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					rem.Id, rem.DueTime.In(loc).Format("2006-01-02 15:04"), rem.Status(), rem.Recipient, rem.Content)
+			}
+			return w.Flush()
+		})
+	}}
+
+var remindersShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Show a reminder",
+	Run: func(cmd *cobra.Command, args []string) {
+		id := parseId(args[0])
+		loc := location()
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			rem, err := load(dao, id)
+			if err != nil {
+				return err
+			}
+			if remindersJSON {
+				return printJSON(api.NewReminderJSON(rem))
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "id:\t%s\n", rem.Id)
+			fmt.Fprintf(w, "content:\t%s\n", rem.Content)
+			fmt.Fprintf(w, "due:\t%s\n", rem.DueTime.In(loc).Format("2006-01-02 15:04 MST"))
+			fmt.Fprintf(w, "status:\t%s\n", rem.Status())
+			fmt.Fprintf(w, "recipient:\t%s\n", rem.Recipient)
+			if len(rem.Recipients) > 0 {
+				fmt.Fprintf(w, "recipients:\t%s\n", strings.Join(rem.Recipients, ", "))
+			}
+			if len(rem.Tags) > 0 {
+				fmt.Fprintf(w, "tags:\t%s\n", strings.Join(rem.Tags, ", "))
+			}
+			fmt.Fprintf(w, "priority:\t%s\n", rem.Priority)
+			if rem.CanEscalate() {
+				fmt.Fprintf(w, "escalation:\t%s after %s (acknowledged: %t, escalated: %t)\n",
+					rem.EscalateTo, rem.EscalateAfter, rem.IsAcknowledged, rem.IsEscalated)
+			}
+			if rem.GeneratedById != "" {
+				fmt.Fprintf(w, "message id:\t%s\n", rem.GeneratedById)
+			}
+			return w.Flush()
+		})
+	}}
+
+var remindersAddCmd = &cobra.Command{
+	Use:   "add --to <email> --at <time> --content <text>",
+	Args:  cobra.NoArgs,
+	Short: "Add a reminder",
+	Run: func(cmd *cobra.Command, args []string) {
+		rem, err := newReminder(reminderTo, parseTimeFlag("at", reminderAt, location()), reminderContent)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid reminder")
+		}
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			if err := dao.Save(rem); err != nil {
+				return err
+			}
+			fmt.Println(rem.Id)
+			return nil
+		})
+	}}
+
+// newReminder validates like the HTTP API.
+func newReminder(to string, at time.Time, content string) (*reminder.Reminder, error) {
+	if err := reminder.CheckContent(content); err != nil {
+		return nil, err
+	}
+	address, err := mail.ParseAddress(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q", to)
+	}
+	return &reminder.Reminder{
+		Id:        uuid.Must(uuid.NewV1()),
+		DueTime:   at,
+		Recipient: address.Address,
+		Content:   content,
+	}, nil
+}
+
+var remindersCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Cancel a reminder",
+	Run: func(cmd *cobra.Command, args []string) {
+		id := parseId(args[0])
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			return dao.Cancel(id)
+		})
+	}}
+
+var remindersRescheduleCmd = &cobra.Command{
+	Use:   "reschedule <id> --at <time>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Change the due time of a reminder, which is sent again if it already was",
+	Run: func(cmd *cobra.Command, args []string) {
+		id := parseId(args[0])
+		at := parseTimeFlag("at", reminderAt, location())
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			return dao.Snooze(id, at)
+		})
+	}}
+
+var remindersRequeueCmd = &cobra.Command{
+	Use:   "requeue <id>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Send a reminder again, now if it is past due",
+	Run: func(cmd *cobra.Command, args []string) {
+		id := parseId(args[0])
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			rem, err := load(dao, id)
+			if err != nil {
+				return err
+			}
+			if rem.IsCancelled {
+				return fmt.Errorf("reminder %q is cancelled", id)
+			}
+			return dao.Requeue(id)
+		})
+	}}
+
+var remindersPurgeCmd = &cobra.Command{
+	Use:   "purge --before <time>",
+	Args:  cobra.NoArgs,
+	Short: "Delete the sent and cancelled reminders due before a time",
+	Run: func(cmd *cobra.Command, args []string) {
+		before := parseTimeFlag("before", purgeBefore, location())
+		withReminderDAO(func(dao *reminder.ReminderDAO) error {
+			n, err := dao.Purge(before)
+			if err != nil {
+				return err
+			}
+			if purgeDryRun {
+				fmt.Printf("would purge %d reminders\n", n)
+				return errDryRun
+			}
+			fmt.Printf("purged %d reminders\n", n)
+			return nil
+		})
+	}}
+
+var errDryRun = fmt.Errorf("dry run")
+
+func withReminderDAO(f func(dao *reminder.ReminderDAO) error) {
+	err := withTxErr(func(ctx context.Context, tx pgx.Tx) error {
+		return f(&reminder.ReminderDAO{Tx: tx, Context: ctx})
+	})
+	if err != nil && err != errDryRun {
+		log.Fatal().Err(err).Msg("")
+	}
+}
+
+func load(dao *reminder.ReminderDAO, id uuid.UUID) (*reminder.Reminder, error) {
+	rem, err := dao.Load(id)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("no reminder %q", id)
+	}
+	return rem, err
+}
+
+func parseId(s string) uuid.UUID {
+	id, err := uuid.FromString(s)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("invalid reminder id %q", s)
+	}
+	return id
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(viper.GetString("timezone"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading location")
+	}
+	return loc
+}
+
+var timeLayouts = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func parseTimeFlag(name string, value string, loc *time.Location) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := parseTime(value, loc)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("invalid --%s", name)
+	}
+	return t
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
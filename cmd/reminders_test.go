@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]time.Time{
+		"2023-04-05 10:30":          time.Date(2023, 4, 5, 10, 30, 0, 0, loc),
+		"2023-04-05T10:30":          time.Date(2023, 4, 5, 10, 30, 0, 0, loc),
+		"2023-04-05":                time.Date(2023, 4, 5, 0, 0, 0, 0, loc),
+		"2023-04-05T10:30:00Z":      time.Date(2023, 4, 5, 10, 30, 0, 0, time.UTC),
+		"2023-04-05T10:30:00+02:00": time.Date(2023, 4, 5, 8, 30, 0, 0, time.UTC),
+	}
+	for s, expected := range cases {
+		if got, err := parseTime(s, loc); err != nil || !got.Equal(expected) {
+			t.Errorf("%s: got %s (%v), expected %s", s, got, err, expected)
+		}
+	}
+	for _, s := range []string{"", "tomorrow", "2023-13-01", "05/04/2023 10:30"} {
+		if _, err := parseTime(s, loc); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestNewReminder(t *testing.T) {
+	at := time.Date(2023, 4, 5, 10, 30, 0, 0, time.UTC)
+	rem, err := newReminder("Alice <alice@example.com>", at, "buy goobers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rem.Recipient != "alice@example.com" || rem.Content != "buy goobers" || !rem.DueTime.Equal(at) {
+		t.Errorf("got %+v", rem)
+	}
+	if _, err := newReminder("alice", at, "buy goobers"); err == nil {
+		t.Error("expected error for a recipient without @")
+	}
+	if _, err := newReminder("alice@example.com", at, "  "); err == nil {
+		t.Error("expected error for empty content")
+	}
+	if _, err := newReminder("alice@example.com", at, "buy\r\nBcc: mallory@evil.com"); err == nil {
+		t.Error("expected error for content with a line break")
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	routesCmd.AddCommand(routesListCmd)
+	routesCmd.AddCommand(routesSetCmd)
+	routesCmd.AddCommand(routesDeleteCmd)
+	rootCmd.AddCommand(routesCmd)
+}
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Manage the notification routes of users",
+}
+
+var routesListCmd = &cobra.Command{
+	Use:   "list <email>",
+	Args:  cobra.ExactArgs(1),
+	Short: "List the routes of a user",
+	Run: func(cmd *cobra.Command, args []string) {
+		withUserDAO(func(users *reminder.UserDAO) error {
+			routes, err := users.Routes(args[0])
+			if err != nil {
+				return err
+			}
+			for _, route := range routes {
+				fmt.Printf("%s\t%s\n", route.Match, strings.Join(route.Channels, ","))
+			}
+			return nil
+		})
+	}}
+
+var routesSetCmd = &cobra.Command{
+	Use:   "set <email> <#tag|!priority|*> <channel>...",
+	Args:  cobra.MinimumNArgs(3),
+	Short: "Set the channels of a route of a user",
+	Run: func(cmd *cobra.Command, args []string) {
+		match, err := reminder.ParseRouteMatch(args[1])
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		channels, err := reminder.ParseRouteChannels(strings.Join(args[2:], " "))
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		withUserDAO(func(users *reminder.UserDAO) error {
+			return users.SaveRoute(&reminder.Route{Email: args[0], Match: match, Channels: channels})
+		})
+	}}
+
+var routesDeleteCmd = &cobra.Command{
+	Use:   "delete <email> <#tag|!priority|*>",
+	Args:  cobra.ExactArgs(2),
+	Short: "Delete a route of a user",
+	Run: func(cmd *cobra.Command, args []string) {
+		match, err := reminder.ParseRouteMatch(args[1])
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		withUserDAO(func(users *reminder.UserDAO) error {
+			return users.DeleteRoute(args[0], match)
+		})
+	}}
+
+func withUserDAO(f func(users *reminder.UserDAO) error) {
+	withTx(func(ctx context.Context, tx pgx.Tx) error {
+		return f(&reminder.UserDAO{Tx: tx, Context: ctx})
+	})
+}
@@ -3,16 +3,21 @@ package cmd
 import (
 	"context"
 	"os"
+	"text/tabwriter"
 
 	"github.com/jbchouinard/mxremind/pkg/config"
 	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/mail"
 	"github.com/jbchouinard/mxremind/pkg/reminder"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+var batchDryRun bool
+
 func init() {
 	runCmd.Flags().BoolVar(&migrateDatabase, "migrate", false, "migrate database schema")
+	runCmd.Flags().BoolVar(&batchDryRun, "dry-run", false, "fetch and parse mail without moving messages, saving reminders or sending anything")
 
 	rootCmd.AddCommand(runCmd)
 }
@@ -24,10 +29,17 @@ var runCmd = &cobra.Command{
 		ctx := context.Background()
 		conf := config.GetConfig()
 
+		if batchDryRun {
+			dryRun(conf)
+			return
+		}
+
 		if migrateDatabase {
 			if err := db.Migrate(ctx, conf.Database.URL); err != nil {
 				log.Fatal().Err(err).Msg("error applying database migrations")
 			}
+		} else if err := db.CheckSchema(ctx, conf.Database.URL); err != nil {
+			log.Fatal().Err(err).Msg("cannot start")
 		}
 
 		service, err := reminder.NewService(ctx, conf)
@@ -50,3 +62,34 @@ var runCmd = &cobra.Command{
 		}
 	},
 }
+
+// dryRun leaves the fetched mail in the inbox.
+func dryRun(conf *config.Config) {
+	policy, err := reminder.NewPolicy(conf)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error initializing policy")
+	}
+	fetcher, messages, errors := mail.NewMailFetcher(conf, 10, nil)
+	fetcher.Peek = true
+	go func() {
+		fetcher.RunOnce()
+		fetcher.Close()
+	}()
+	failed := make(chan bool)
+	go func() {
+		f := false
+		for err := range errors {
+			log.Error().Err(err).Msg("")
+			f = true
+		}
+		failed <- f
+	}()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for m := range messages {
+		previewMail(w, m, policy)
+	}
+	w.Flush()
+	if <-failed {
+		os.Exit(1)
+	}
+}
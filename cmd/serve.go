@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jbchouinard/mxremind/pkg/api"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/jbchouinard/mxremind/pkg/web"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serveHTTP bool
+
+func init() {
+	startCmd.Flags().BoolVar(&serveHTTP, "http", false, "also serve the HTTP API and web interface")
+	serveCmd.Flags().String("listen", "", "address to listen on (default \":8080\")")
+	viper.BindPFlag("http.listen", serveCmd.Flags().Lookup("listen"))
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the HTTP API and web interface",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		conf := config.GetConfig()
+		if err := db.CheckSchema(ctx, conf.Database.URL); err != nil {
+			log.Fatal().Err(err).Msg("cannot serve")
+		}
+		if err := serve(ctx, conf); err != nil {
+			log.Fatal().Err(err).Msg("error serving HTTP API")
+		}
+	},
+}
+
+func serve(ctx context.Context, conf *config.Config) error {
+	pool, err := db.NewPool(ctx, conf.Database.URL)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	apiServer, err := api.NewServer(pool, conf)
+	if err != nil {
+		return err
+	}
+	webServer, err := web.NewServer(pool, &mail.SmtpSender{Conf: conf.SMTP}, conf)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("serving HTTP API and web interface on %s", conf.HTTP.Listen)
+	return http.ListenAndServe(conf.HTTP.Listen, webServer.Handler(apiServer.Handler()))
+}
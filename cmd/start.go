@@ -29,6 +29,8 @@ var startCmd = &cobra.Command{
 			if err := db.Migrate(ctx, conf.Database.URL); err != nil {
 				log.Fatal().Err(err).Msg("error applying database migrations")
 			}
+		} else if err := db.CheckSchema(ctx, conf.Database.URL); err != nil {
+			log.Fatal().Err(err).Msg("cannot start")
 		}
 		service, err := reminder.NewService(ctx, conf)
 		if err != nil {
@@ -40,6 +42,12 @@ var startCmd = &cobra.Command{
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 		service.Start()
+		httpErrors := make(chan error, 1)
+		if serveHTTP {
+			go func() {
+				httpErrors <- serve(ctx, conf)
+			}()
+		}
 		for {
 			select {
 			case sig := <-sigs:
@@ -48,6 +56,10 @@ var startCmd = &cobra.Command{
 				os.Exit(0)
 			case err := <-service.Errors():
 				log.Error().Err(err).Msg("")
+			case err := <-httpErrors:
+				log.Error().Err(err).Msg("error serving HTTP API, shutting down")
+				service.Stop()
+				os.Exit(1)
 			}
 		}
 	},
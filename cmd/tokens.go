@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var tokenName string
+var tokenAdmin bool
+
+func init() {
+	tokensCreateCmd.Flags().StringVar(&tokenName, "name", "", "name of the token")
+	tokensCreateCmd.Flags().BoolVar(&tokenAdmin, "admin", false, "allow managing the reminders of all users")
+	tokensCmd.AddCommand(tokensCreateCmd)
+	tokensCmd.AddCommand(tokensListCmd)
+	tokensCmd.AddCommand(tokensRevokeCmd)
+	rootCmd.AddCommand(tokensCmd)
+}
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Manage API tokens",
+}
+
+var tokensCreateCmd = &cobra.Command{
+	Use:   "create <email>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Create an API token for a user",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTx(func(ctx context.Context, tx pgx.Tx) error {
+			token, err := (&api.TokenDAO{Tx: tx, Context: ctx}).Create(args[0], tokenName, tokenAdmin)
+			if err == nil {
+				fmt.Println(token)
+			}
+			return err
+		})
+	}}
+
+var tokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTx(func(ctx context.Context, tx pgx.Tx) error {
+			tokens, err := (&api.TokenDAO{Tx: tx, Context: ctx}).List()
+			for _, t := range tokens {
+				admin := ""
+				if t.IsAdmin {
+					admin = "admin"
+				}
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\n", t.Id(), t.Email, t.Name, admin, t.CreatedAt.Format("2006-01-02"))
+			}
+			return err
+		})
+	}}
+
+var tokensRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Revoke an API token",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTx(func(ctx context.Context, tx pgx.Tx) error {
+			return (&api.TokenDAO{Tx: tx, Context: ctx}).Revoke(args[0])
+		})
+	}}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/rs/zerolog/log"
+)
+
+func withTx(f func(ctx context.Context, tx pgx.Tx) error) {
+	if err := withTxErr(f); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+}
+
+// Errors connecting to the database are still fatal.
+func withTxErr(f func(ctx context.Context, tx pgx.Tx) error) error {
+	ctx := context.Background()
+	conf := config.GetDatabaseConfig("database")
+	pool, err := db.NewPool(ctx, conf.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error connecting to database")
+	}
+	defer pool.Close()
+	return db.WithTx(ctx, pool, func(tx pgx.Tx) error {
+		return f(ctx, tx)
+	})
+}
@@ -0,0 +1,433 @@
+// Package api implements the HTTP API for managing reminders.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+	"github.com/rs/zerolog/log"
+)
+
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+func errorf(status int, format string, args ...any) *Error {
+	return &Error{status, fmt.Sprintf(format, args...)}
+}
+
+type ReminderJSON struct {
+	Id             uuid.UUID `json:"id"`
+	Recipient      string    `json:"recipient"`
+	Recipients     []string  `json:"recipients"`
+	Content        string    `json:"content"`
+	DueTime        time.Time `json:"due_time"`
+	Status         string    `json:"status"`
+	Tags           []string  `json:"tags"`
+	Priority       string    `json:"priority"`
+	EscalateTo     string    `json:"escalate_to,omitempty"`
+	EscalateAfter  string    `json:"escalate_after,omitempty"`
+	IsAcknowledged bool      `json:"is_acknowledged"`
+}
+
+func NewReminderJSON(rem *reminder.Reminder) *ReminderJSON {
+	r := &ReminderJSON{
+		Id:             rem.Id,
+		Recipient:      rem.Recipient,
+		Recipients:     rem.Recipients,
+		Content:        rem.Content,
+		DueTime:        rem.DueTime.UTC(),
+		Status:         rem.Status(),
+		Tags:           rem.Tags,
+		Priority:       rem.Priority,
+		EscalateTo:     rem.EscalateTo,
+		IsAcknowledged: rem.IsAcknowledged,
+	}
+	if r.Recipients == nil {
+		r.Recipients = []string{}
+	}
+	if r.Tags == nil {
+		r.Tags = []string{}
+	}
+	if r.Priority == "" {
+		r.Priority = reminder.PriorityNormal
+	}
+	if rem.CanEscalate() {
+		r.EscalateAfter = rem.EscalateAfter.String()
+	}
+	return r
+}
+
+// A new reminder is parsed from Subject, or made from Content and DueTime.
+type ReminderRequest struct {
+	Recipient     *string    `json:"recipient"`
+	Subject       *string    `json:"subject"`
+	Timezone      *string    `json:"timezone"`
+	Content       *string    `json:"content"`
+	DueTime       *time.Time `json:"due_time"`
+	Recipients    []string   `json:"recipients"`
+	EscalateTo    *string    `json:"escalate_to"`
+	EscalateAfter *string    `json:"escalate_after"`
+	Tags          []string   `json:"tags"`
+	Priority      *string    `json:"priority"`
+}
+
+type SnoozeRequest struct {
+	Duration *string    `json:"duration"`
+	Until    *time.Time `json:"until"`
+}
+
+type Server struct {
+	Pool     *pgxpool.Pool
+	Quota    *reminder.Quota
+	Policy   *reminder.RecipientPolicy
+	Location *time.Location
+}
+
+func NewServer(pool *pgxpool.Pool, conf *config.Config) (*Server, error) {
+	policy, err := reminder.NewPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{pool, reminder.NewQuota(conf.Limits), policy.Recipients, conf.Location()}, nil
+}
+
+type handler func(r *http.Request, token *Token) (any, error)
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/reminders", s.authenticated(s.handleReminders))
+	mux.Handle("/api/reminders/", s.authenticated(s.handleReminder))
+	mux.Handle("/api/parse", s.authenticated(s.handleParse))
+	return mux
+}
+
+func (s *Server) authenticated(h handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := s.authenticate(r, h)
+		if err != nil {
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				log.Error().Err(err).Msgf("%s %s", r.Method, r.URL.Path)
+				apiErr = errorf(http.StatusInternalServerError, "internal error")
+			}
+			writeJSON(w, apiErr.Status, map[string]string{"error": apiErr.Message})
+			return
+		}
+		if result == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		status := http.StatusOK
+		if r.Method == http.MethodPost && r.URL.Path == "/api/reminders" {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, result)
+	})
+}
+
+func (s *Server) authenticate(r *http.Request, h handler) (any, error) {
+	header := r.Header.Get("Authorization")
+	bearer := strings.TrimPrefix(header, "Bearer ")
+	if bearer == header || bearer == "" {
+		return nil, errorf(http.StatusUnauthorized, "missing API token")
+	}
+	var token *Token
+	err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		var err error
+		token, err = (&TokenDAO{tx, r.Context()}).Lookup(bearer)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errorf(http.StatusUnauthorized, "invalid API token")
+	}
+	return h(r, token)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("error writing response")
+	}
+}
+
+func readJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) withDAO(ctx context.Context, f func(dao *reminder.ReminderDAO) error) error {
+	return db.WithTx(ctx, s.Pool, func(tx pgx.Tx) error {
+		return f(&reminder.ReminderDAO{Tx: tx, Context: ctx})
+	})
+}
+
+func canAccess(token *Token, email string) bool {
+	return token.IsAdmin || strings.EqualFold(token.Email, email)
+}
+
+func load(dao *reminder.ReminderDAO, token *Token, id uuid.UUID) (*reminder.Reminder, error) {
+	rem, err := dao.Load(id)
+	if err == pgx.ErrNoRows || (err == nil && !canAccess(token, rem.Recipient)) {
+		return nil, errorf(http.StatusNotFound, "no reminder %s", id)
+	}
+	return rem, err
+}
+
+func (s *Server) handleReminders(r *http.Request, token *Token) (any, error) {
+	switch r.Method {
+	case http.MethodGet:
+		user := r.URL.Query().Get("user")
+		if user == "" {
+			user = token.Email
+		}
+		if !canAccess(token, user) {
+			return nil, errorf(http.StatusForbidden, "cannot list reminders of %s", user)
+		}
+		status := r.URL.Query().Get("status")
+		reminders := make([]*ReminderJSON, 0)
+		err := s.withDAO(r.Context(), func(dao *reminder.ReminderDAO) error {
+			rems, err := dao.QueryRecipient(user)
+			for _, rem := range rems {
+				if status == "" || rem.Status() == status {
+					reminders = append(reminders, NewReminderJSON(rem))
+				}
+			}
+			return err
+		})
+		return reminders, err
+	case http.MethodPost:
+		var req ReminderRequest
+		if err := readJSON(r, &req); err != nil {
+			return nil, err
+		}
+		rem, err := s.newReminder(&req, token)
+		if err != nil {
+			return nil, err
+		}
+		err = s.withDAO(r.Context(), func(dao *reminder.ReminderDAO) error {
+			var quotaErr *reminder.QuotaError
+			if err := s.Quota.CheckNew(dao, rem); errors.As(err, &quotaErr) {
+				return errorf(http.StatusTooManyRequests, "%s", quotaErr)
+			} else if err != nil {
+				return err
+			}
+			return dao.Save(rem)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewReminderJSON(rem), nil
+	default:
+		return nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+func (s *Server) handleReminder(r *http.Request, token *Token) (any, error) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/reminders/"), "/")
+	id, err := uuid.FromString(parts[0])
+	if err != nil || len(parts) > 2 {
+		return nil, errorf(http.StatusNotFound, "not found")
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	route := r.Method + " " + action
+	var result any
+	err = s.withDAO(r.Context(), func(dao *reminder.ReminderDAO) error {
+		rem, err := load(dao, token, id)
+		if err != nil {
+			return err
+		}
+		switch route {
+		case "GET ":
+		case "PATCH ":
+			var req ReminderRequest
+			if err := readJSON(r, &req); err != nil {
+				return err
+			}
+			if rem.IsSent || rem.IsCancelled {
+				return errorf(http.StatusConflict, "reminder %s is %s", id, rem.Status())
+			}
+			if err := s.apply(rem, &req); err != nil {
+				return err
+			}
+			if err := dao.Update(rem); err != nil {
+				return err
+			}
+			// Clears any hold from quiet hours for the previous due time.
+			if err := dao.Snooze(rem.Id, rem.DueTime); err != nil {
+				return err
+			}
+		case "DELETE ":
+			return dao.Delete(rem)
+		case "POST cancel":
+			if err := dao.Cancel(id); err != nil {
+				return err
+			}
+			rem.IsCancelled = true
+		case "POST snooze":
+			var req SnoozeRequest
+			if err := readJSON(r, &req); err != nil {
+				return err
+			}
+			until, err := snoozeUntil(&req, time.Now())
+			if err != nil {
+				return err
+			}
+			if err := dao.Snooze(id, until); err != nil {
+				return err
+			}
+			rem.DueTime, rem.IsSent, rem.IsAcknowledged, rem.IsEscalated = until, false, false, false
+		default:
+			return errorf(http.StatusMethodNotAllowed, "%s %s not allowed", r.Method, r.URL.Path)
+		}
+		result = NewReminderJSON(rem)
+		return nil
+	})
+	return result, err
+}
+
+func snoozeUntil(req *SnoozeRequest, now time.Time) (time.Time, error) {
+	switch {
+	case req.Until != nil:
+		return *req.Until, nil
+	case req.Duration != nil:
+		d, err := time.ParseDuration(*req.Duration)
+		if err != nil || d <= 0 {
+			return time.Time{}, errorf(http.StatusBadRequest, "invalid duration %q", *req.Duration)
+		}
+		return now.Add(d), nil
+	default:
+		return now.Add(time.Hour), nil
+	}
+}
+
+func (s *Server) handleParse(r *http.Request, token *Token) (any, error) {
+	if r.Method != http.MethodPost {
+		return nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+	var req ReminderRequest
+	if err := readJSON(r, &req); err != nil {
+		return nil, err
+	}
+	rem, err := s.newReminder(&req, token)
+	if err != nil {
+		return nil, err
+	}
+	return NewReminderJSON(rem), nil
+}
+
+func (s *Server) newReminder(req *ReminderRequest, token *Token) (*reminder.Reminder, error) {
+	owner := token.Email
+	if req.Recipient != nil {
+		owner = *req.Recipient
+	}
+	if !canAccess(token, owner) {
+		return nil, errorf(http.StatusForbidden, "cannot create reminders for %s", owner)
+	}
+	loc := s.Location
+	if req.Timezone != nil {
+		var err error
+		if loc, err = time.LoadLocation(*req.Timezone); err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid timezone %q", *req.Timezone)
+		}
+	}
+	var rem *reminder.Reminder
+	if req.Subject != nil {
+		m := &mail.Mail{From: owner, Subject: *req.Subject, Location: loc}
+		var err error
+		if rem, err = reminder.ReminderFromMail(m, s.Policy, time.Now()); err != nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "%s", err)
+		}
+		rem.GeneratedById = "api"
+	} else {
+		if req.Content == nil || req.DueTime == nil {
+			return nil, errorf(http.StatusBadRequest, "subject, or content and due_time, are required")
+		}
+		rem = &reminder.Reminder{
+			Id:            uuid.Must(uuid.NewV1()),
+			GeneratedById: "api",
+			Recipient:     owner,
+			Recipients:    []string{},
+			Tags:          []string{},
+			Priority:      reminder.PriorityNormal,
+		}
+	}
+	if err := s.apply(rem, req); err != nil {
+		return nil, err
+	}
+	return rem, nil
+}
+
+func (s *Server) apply(rem *reminder.Reminder, req *ReminderRequest) error {
+	if req.Content != nil {
+		if err := reminder.CheckContent(*req.Content); err != nil {
+			return errorf(http.StatusBadRequest, "%s", err)
+		}
+		rem.Content = *req.Content
+	}
+	if req.DueTime != nil {
+		rem.DueTime = *req.DueTime
+	}
+	if req.Recipients != nil {
+		recipients, denied := s.Policy.Filter(rem.Recipient, req.Recipients)
+		if len(denied) > 0 {
+			return errorf(http.StatusForbidden, "recipients not allowed: %s", strings.Join(denied, ", "))
+		}
+		rem.Recipients = recipients
+	}
+	if req.EscalateTo != nil {
+		if *req.EscalateTo != "" && !s.Policy.AllowEscalation(rem.Recipient, *req.EscalateTo) {
+			return errorf(http.StatusForbidden, "escalation to %s not allowed", *req.EscalateTo)
+		}
+		rem.EscalateTo = *req.EscalateTo
+	}
+	if req.EscalateAfter != nil {
+		d, err := time.ParseDuration(*req.EscalateAfter)
+		if err != nil || d <= 0 {
+			return errorf(http.StatusBadRequest, "invalid escalate_after %q", *req.EscalateAfter)
+		}
+		rem.EscalateAfter = d
+	}
+	if rem.CanEscalate() && rem.EscalateAfter <= 0 {
+		return errorf(http.StatusBadRequest, "escalate_after is required with escalate_to")
+	}
+	if req.Tags != nil {
+		rem.Tags = make([]string, len(req.Tags))
+		for i, tag := range req.Tags {
+			rem.Tags[i] = strings.ToLower(strings.TrimPrefix(tag, "#"))
+		}
+	}
+	if req.Priority != nil {
+		if !reminder.IsPriority(*req.Priority) {
+			return errorf(http.StatusBadRequest, "invalid priority %q", *req.Priority)
+		}
+		rem.Priority = *req.Priority
+	}
+	return nil
+}
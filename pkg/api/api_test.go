@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+)
+
+func testServer(t *testing.T) *Server {
+	allow, err := reminder.NewAddressList([]string{"@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{Policy: &reminder.RecipientPolicy{Mailbox: "reminders@example.com", Allow: allow}, Location: loc}
+}
+
+// testDatabase migrates the database of MXREMIND_TEST_DATABASE_URL and returns
+// a pool on it, or skips the test if it is not set.
+func testDatabase(t *testing.T) *pgxpool.Pool {
+	url := os.Getenv("MXREMIND_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("MXREMIND_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	if err := db.Migrate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := db.NewPool(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestMissingToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	testServer(t).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/reminders", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestNewReminder(t *testing.T) {
+	s := testServer(t)
+	token := &Token{Email: "alice@example.com"}
+	subject := "2023-04-05 12:00 deploy #work !high @bob@example.com"
+	rem, err := s.newReminder(&ReminderRequest{Subject: &subject}, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rem.Content != "deploy" || rem.Recipient != "alice@example.com" || rem.Priority != reminder.PriorityHigh ||
+		len(rem.Recipients) != 1 || rem.DueTime.UTC().Hour() != 16 {
+		t.Errorf("got %+v", rem)
+	}
+
+	other := "bob@example.com"
+	if _, err := s.newReminder(&ReminderRequest{Subject: &subject, Recipient: &other}, token); err == nil {
+		t.Error("expected error creating a reminder for another user")
+	}
+	token.IsAdmin = true
+	if rem, err := s.newReminder(&ReminderRequest{Subject: &subject, Recipient: &other}, token); err != nil || rem.Recipient != other {
+		t.Errorf("admin: got %v %v", rem, err)
+	}
+
+	escalateTo, escalateAfter := "mallory@evil.com", "30m"
+	if _, err := s.newReminder(&ReminderRequest{Subject: &subject, EscalateTo: &escalateTo, EscalateAfter: &escalateAfter}, token); err == nil {
+		t.Error("expected error for a denied escalation contact")
+	}
+	escalateTo = "lead@example.com"
+	if rem, err := s.newReminder(&ReminderRequest{Subject: &subject, EscalateTo: &escalateTo, EscalateAfter: &escalateAfter}, token); err != nil || !rem.CanEscalate() {
+		t.Errorf("allowed escalation contact: got %v %v", rem, err)
+	}
+
+	priority := "someday"
+	if _, err := s.newReminder(&ReminderRequest{Subject: &subject, Priority: &priority}, token); err == nil {
+		t.Error("expected error for invalid priority")
+	}
+
+	content := "deploy\r\nBcc: mallory@evil.com"
+	if _, err := s.newReminder(&ReminderRequest{Subject: &subject, Content: &content}, token); err == nil {
+		t.Error("expected error for content with a line break")
+	}
+}
+
+func TestPatchRecipients(t *testing.T) {
+	s := testServer(t)
+	s.Pool = testDatabase(t)
+	s.Quota = reminder.NewQuota(&config.LimitsConfig{})
+	ctx := context.Background()
+	var token string
+	err := db.WithTx(ctx, s.Pool, func(tx pgx.Tx) error {
+		var err error
+		token, err = (&TokenDAO{tx, ctx}).Create("alice@example.com", "test", false)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method string, path string, body string) *ReminderJSON {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code >= 300 {
+			t.Fatalf("%s %s: got %d %s", method, path, w.Code, w.Body)
+		}
+		var rem ReminderJSON
+		if w.Code != http.StatusNoContent {
+			if err := json.NewDecoder(w.Body).Decode(&rem); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &rem
+	}
+
+	created := request(http.MethodPost, "/api/reminders",
+		`{"content": "deploy", "due_time": "2030-01-02T15:04:05Z", "recipients": ["bob@example.com"]}`)
+	path := "/api/reminders/" + created.Id.String()
+	defer request(http.MethodDelete, path, "")
+	request(http.MethodPatch, path, `{"recipients": ["carol@example.com", "dave@example.com"]}`)
+	rem := request(http.MethodGet, path, "")
+	if strings.Join(rem.Recipients, " ") != "carol@example.com dave@example.com" {
+		t.Errorf("got recipients %v after PATCH", rem.Recipients)
+	}
+}
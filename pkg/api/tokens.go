@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/db"
+)
+
+const TokenPrefix = "mxr_"
+
+// Only a hash of tokens is stored. Admin tokens can manage all reminders.
+type Token struct {
+	Hash      string
+	Email     string
+	Name      string
+	IsAdmin   bool
+	CreatedAt time.Time
+}
+
+func (t *Token) Id() string {
+	return t.Hash[:12]
+}
+
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + hex.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type TokenDAO struct {
+	Tx      pgx.Tx
+	Context context.Context
+}
+
+func (dao *TokenDAO) Create(email string, name string, isAdmin bool) (string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = dao.Tx.Exec(
+		dao.Context,
+		`INSERT INTO api_tokens (token_hash, email, name, is_admin) VALUES ($1, lower($2), $3, $4)`,
+		HashToken(token),
+		email,
+		name,
+		isAdmin,
+	)
+	return token, err
+}
+
+func (dao *TokenDAO) Lookup(token string) (*Token, error) {
+	t, err := dao.scan(dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT token_hash, email, name, is_admin, created_at FROM api_tokens WHERE token_hash = $1`,
+		HashToken(token),
+	))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (dao *TokenDAO) List() ([]*Token, error) {
+	rows, err := dao.Tx.Query(
+		dao.Context,
+		`SELECT token_hash, email, name, is_admin, created_at FROM api_tokens ORDER BY email, created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]*Token, 0)
+	for rows.Next() {
+		t, err := dao.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return tokens, nil
+}
+
+func (dao *TokenDAO) Revoke(id string) error {
+	return db.DeleteByHashPrefix(dao.Context, dao.Tx, "api_tokens", "token", id)
+}
+
+func (dao *TokenDAO) scan(row pgx.Row) (*Token, error) {
+	var t Token
+	err := row.Scan(&t.Hash, &t.Email, &t.Name, &t.IsAdmin, &t.CreatedAt)
+	return &t, err
+}
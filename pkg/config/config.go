@@ -2,6 +2,10 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -11,14 +15,22 @@ import (
 func init() {
 	viper.SetDefault("smtp.port", 587)
 	viper.SetDefault("smtp.authenticated", true)
+	viper.SetDefault("smtp.auth_mechanism", "plain")
+	viper.SetDefault("smtp.pool.size", 4)
+	viper.SetDefault("smtp.pool.idle_timeout", 60)
 	viper.SetDefault("smtp.tls.enabled", true)
 	viper.SetDefault("smtp.tls.insecure", false)
 	viper.SetDefault("imap.port", 993)
 	viper.SetDefault("imap.tls.enabled", true)
 	viper.SetDefault("imap.tls.insecure", false)
 	viper.SetDefault("imap.authenticated", true)
+	viper.SetDefault("imap.auth_mechanism", "plain")
 	viper.SetDefault("send_interval", 60)
 	viper.SetDefault("fetch_interval", 60)
+	viper.SetDefault("webhook.timeout", 10)
+	viper.SetDefault("webhook.retries", 3)
+	viper.SetDefault("http.listen", ":8080")
+	viper.SetDefault("http.base_url", "http://localhost:8080")
 }
 
 func assertKeys(required []string) {
@@ -29,52 +41,181 @@ func assertKeys(required []string) {
 	}
 }
 
+const (
+	TlsImplicit         = "implicit"
+	TlsStartTls         = "starttls"
+	TlsStartTlsRequired = "starttls-required"
+	TlsNone             = "none"
+)
+
 type TlsConfig struct {
-	Enabled  bool `yaml:"enabled"`
-	Insecure bool `yaml:"insecure"`
+	Enabled    bool   `yaml:"enabled"`
+	Insecure   bool   `yaml:"insecure"`
+	Mode       string `yaml:"mode"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	MinVersion string `yaml:"min_version"`
 }
 
-type ServerConfig struct {
-	Address       string     `yaml:"address"`
-	Password      string     `yaml:"password"`
-	Authenticated bool       `yaml:"authenticated"`
-	Host          string     `yaml:"host"`
-	Port          uint16     `yaml:"port"`
-	Tls           *TlsConfig `yaml:"tls"`
+// Without a mode, GetMode returns none if TLS is disabled, otherwise defaultMode.
+func (tc *TlsConfig) GetMode(defaultMode string) (string, error) {
+	switch tc.Mode {
+	case "":
+		if !tc.Enabled {
+			return TlsNone, nil
+		}
+		return defaultMode, nil
+	case TlsImplicit, TlsStartTls, TlsStartTlsRequired, TlsNone:
+		return tc.Mode, nil
+	default:
+		return "", fmt.Errorf("unknown TLS mode %q", tc.Mode)
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
 }
 
-func (sc *ServerConfig) TlsConfig() *tls.Config {
-	return &tls.Config{
+func (sc *ServerConfig) TlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{
 		InsecureSkipVerify: sc.Tls.Insecure,
 		ServerName:         sc.Host,
 	}
+	if sc.Tls.MinVersion != "" {
+		version, ok := tlsVersions[sc.Tls.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", sc.Tls.MinVersion)
+		}
+		conf.MinVersion = version
+	}
+	if sc.Tls.CAFile != "" {
+		pem, err := os.ReadFile(sc.Tls.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", sc.Tls.CAFile)
+		}
+	}
+	if sc.Tls.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(sc.Tls.CertFile, sc.Tls.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+type OAuth2Config struct {
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RefreshToken string   `yaml:"refresh_token"`
+	AccessToken  string   `yaml:"access_token"`
+	Scopes       []string `yaml:"scopes"`
+	CacheFile    string   `yaml:"cache_file"`
+}
+
+func GetOAuth2Config(prefix string) *OAuth2Config {
+	return &OAuth2Config{
+		TokenURL:     viper.GetString(prefix + ".token_url"),
+		ClientID:     viper.GetString(prefix + ".client_id"),
+		ClientSecret: viper.GetString(prefix + ".client_secret"),
+		RefreshToken: viper.GetString(prefix + ".refresh_token"),
+		AccessToken:  viper.GetString(prefix + ".access_token"),
+		Scopes:       viper.GetStringSlice(prefix + ".scopes"),
+		CacheFile:    viper.GetString(prefix + ".cache_file"),
+	}
+}
+
+// A PoolConfig size of 0 disables pooling.
+type PoolConfig struct {
+	Size        int    `yaml:"size"`
+	IdleTimeout uint16 `yaml:"idle_timeout"`
+}
+
+func (pc *PoolConfig) IdleTimeoutDuration() time.Duration {
+	return time.Duration(pc.IdleTimeout) * time.Second
+}
+
+type ServerConfig struct {
+	Address       string        `yaml:"address"`
+	Password      string        `yaml:"password"`
+	Authenticated bool          `yaml:"authenticated"`
+	AuthMechanism string        `yaml:"auth_mechanism"`
+	OAuth2        *OAuth2Config `yaml:"oauth2"`
+	Host          string        `yaml:"host"`
+	Port          uint16        `yaml:"port"`
+	Tls           *TlsConfig    `yaml:"tls"`
+	Pool          *PoolConfig   `yaml:"pool"`
+}
+
+func serverKeys(prefix string) []string {
+	keys := []string{
+		prefix + ".address",
+		prefix + ".authenticated",
+		prefix + ".auth_mechanism",
+		prefix + ".host",
+		prefix + ".port",
+		prefix + ".tls.enabled",
+		prefix + ".tls.insecure",
+	}
+	if strings.ToLower(viper.GetString(prefix+".auth_mechanism")) == "plain" {
+		keys = append(keys, prefix+".password")
+	}
+	return keys
 }
 
 func GetServerConfig(prefix string) *ServerConfig {
 	addressKey := prefix + ".address"
 	passwordKey := prefix + ".password"
 	authenticatedKey := prefix + ".authenticated"
+	mechanismKey := prefix + ".auth_mechanism"
 	hostKey := prefix + ".host"
 	portKey := prefix + ".port"
 	tlsKey := prefix + ".tls.enabled"
 	insecureKey := prefix + ".tls.insecure"
-	assertKeys([]string{addressKey, passwordKey, authenticatedKey, hostKey, portKey, tlsKey, insecureKey})
+	assertKeys(serverKeys(prefix))
+	mechanism := strings.ToLower(viper.GetString(mechanismKey))
+	oauth2 := GetOAuth2Config(prefix + ".oauth2")
+	if mechanism == "plain" {
+		oauth2 = nil
+	}
 	return &ServerConfig{
 		Address:       viper.GetString(addressKey),
 		Password:      viper.GetString(passwordKey),
 		Authenticated: viper.GetBool(authenticatedKey),
+		AuthMechanism: mechanism,
+		OAuth2:        oauth2,
 		Host:          viper.GetString(hostKey),
 		Port:          viper.GetUint16(portKey),
 		Tls: &TlsConfig{
-			Enabled:  viper.GetBool(tlsKey),
-			Insecure: viper.GetBool(insecureKey),
+			Enabled:    viper.GetBool(tlsKey),
+			Insecure:   viper.GetBool(insecureKey),
+			Mode:       strings.ToLower(viper.GetString(prefix + ".tls.mode")),
+			CAFile:     viper.GetString(prefix + ".tls.ca_file"),
+			CertFile:   viper.GetString(prefix + ".tls.cert_file"),
+			KeyFile:    viper.GetString(prefix + ".tls.key_file"),
+			MinVersion: viper.GetString(prefix + ".tls.min_version"),
+		},
+		Pool: &PoolConfig{
+			Size:        viper.GetInt(prefix + ".pool.size"),
+			IdleTimeout: viper.GetUint16(prefix + ".pool.idle_timeout"),
 		},
 	}
 }
 
 type MailboxConfig struct {
+	Address   string `yaml:"address"`
 	In        string `yaml:"in"`
 	Processed string `yaml:"processed"`
+	Rejected  string `yaml:"rejected"`
 }
 
 func GetMailboxConfig(prefix string) *MailboxConfig {
@@ -82,9 +223,19 @@ func GetMailboxConfig(prefix string) *MailboxConfig {
 	processedKey := prefix + ".processed"
 	assertKeys([]string{inKey, processedKey})
 	return &MailboxConfig{
+		Address:   MailboxAddress(prefix),
 		In:        viper.GetString(inKey),
 		Processed: viper.GetString(processedKey),
+		Rejected:  viper.GetString(prefix + ".rejected"),
+	}
+}
+
+// MailboxAddress falls back to the IMAP login.
+func MailboxAddress(prefix string) string {
+	if address := viper.GetString(prefix + ".address"); address != "" {
+		return address
 	}
+	return viper.GetString("imap.address")
 }
 
 type DatabaseConfig struct {
@@ -99,14 +250,169 @@ func GetDatabaseConfig(prefix string) *DatabaseConfig {
 	}
 }
 
+type RecipientsConfig struct {
+	Allow []string `yaml:"allow"`
+}
+
+func GetRecipientsConfig(prefix string) *RecipientsConfig {
+	return &RecipientsConfig{
+		Allow: viper.GetStringSlice(prefix + ".allow"),
+	}
+}
+
+type SendersConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+func GetSendersConfig(prefix string) *SendersConfig {
+	return &SendersConfig{
+		Allow: viper.GetStringSlice(prefix + ".allow"),
+		Deny:  viper.GetStringSlice(prefix + ".deny"),
+	}
+}
+
+type DKIMConfig struct {
+	Verify bool              `yaml:"verify"`
+	Keys   map[string]string `yaml:"keys"`
+}
+
+type AuthConfig struct {
+	Require        []string    `yaml:"require"`
+	TrustedServers []string    `yaml:"trusted_servers"`
+	DKIM           *DKIMConfig `yaml:"dkim"`
+}
+
+func GetAuthConfig(prefix string) *AuthConfig {
+	return &AuthConfig{
+		Require:        viper.GetStringSlice(prefix + ".require"),
+		TrustedServers: viper.GetStringSlice(prefix + ".trusted_servers"),
+		DKIM: &DKIMConfig{
+			Verify: viper.GetBool(prefix + ".dkim.verify"),
+			Keys:   viper.GetStringMapString(prefix + ".dkim.keys"),
+		},
+	}
+}
+
+type LimitsConfig struct {
+	ActiveReminders  int `yaml:"active_reminders"`
+	RemindersPerHour int `yaml:"reminders_per_hour"`
+	MailsPerDay      int `yaml:"mails_per_day"`
+}
+
+func GetLimitsConfig(prefix string) *LimitsConfig {
+	return &LimitsConfig{
+		ActiveReminders:  viper.GetInt(prefix + ".active_reminders"),
+		RemindersPerHour: viper.GetInt(prefix + ".reminders_per_hour"),
+		MailsPerDay:      viper.GetInt(prefix + ".mails_per_day"),
+	}
+}
+
+type CalendarConfig struct {
+	Attach bool `yaml:"attach"`
+}
+
+func GetCalendarConfig(prefix string) *CalendarConfig {
+	return &CalendarConfig{
+		Attach: viper.GetBool(prefix + ".attach"),
+	}
+}
+
+type WebhookConfig struct {
+	URL     string `yaml:"url"`
+	Secret  string `yaml:"secret"`
+	Timeout uint16 `yaml:"timeout"`
+	Retries int    `yaml:"retries"`
+}
+
+func GetWebhookConfig(prefix string) *WebhookConfig {
+	return &WebhookConfig{
+		URL:     viper.GetString(prefix + ".url"),
+		Secret:  viper.GetString(prefix + ".secret"),
+		Timeout: viper.GetUint16(prefix + ".timeout"),
+		Retries: viper.GetInt(prefix + ".retries"),
+	}
+}
+
+// Action links are only added to e-mails if SigningKey is set.
+type HTTPConfig struct {
+	Listen     string `yaml:"listen"`
+	BaseURL    string `yaml:"base_url"`
+	SigningKey string `yaml:"signing_key"`
+}
+
+func GetHTTPConfig(prefix string) *HTTPConfig {
+	return &HTTPConfig{
+		Listen:     viper.GetString(prefix + ".listen"),
+		BaseURL:    strings.TrimRight(viper.GetString(prefix+".base_url"), "/"),
+		SigningKey: viper.GetString(prefix + ".signing_key"),
+	}
+}
+
+const (
+	ChannelTypeWebhook = "webhook"
+	ChannelTypeSlack   = "slack"
+	ChannelTypeNtfy    = "ntfy"
+	ChannelTypeMatrix  = "matrix"
+)
+
+// URL is the webhook, ntfy topic or matrix homeserver URL of the channel.
+type ChannelConfig struct {
+	Type    string `yaml:"type"`
+	URL     string `yaml:"url"`
+	Secret  string `yaml:"secret"`
+	Token   string `yaml:"token"`
+	RoomID  string `yaml:"room_id"`
+	Timeout uint16 `yaml:"timeout"`
+	Retries int    `yaml:"retries"`
+}
+
+func (c *ChannelConfig) Webhook() *WebhookConfig {
+	return &WebhookConfig{URL: c.URL, Secret: c.Secret, Timeout: c.Timeout, Retries: c.Retries}
+}
+
+func GetChannelsConfig(prefix string) map[string]*ChannelConfig {
+	channels := make(map[string]*ChannelConfig)
+	for name := range viper.GetStringMap(prefix) {
+		key := prefix + "." + name
+		assertKeys([]string{key + ".type", key + ".url"})
+		conf := &ChannelConfig{
+			Type:    strings.ToLower(viper.GetString(key + ".type")),
+			URL:     viper.GetString(key + ".url"),
+			Secret:  viper.GetString(key + ".secret"),
+			Token:   viper.GetString(key + ".token"),
+			RoomID:  viper.GetString(key + ".room_id"),
+			Timeout: viper.GetUint16("webhook.timeout"),
+			Retries: viper.GetInt("webhook.retries"),
+		}
+		if viper.IsSet(key + ".timeout") {
+			conf.Timeout = viper.GetUint16(key + ".timeout")
+		}
+		if viper.IsSet(key + ".retries") {
+			conf.Retries = viper.GetInt(key + ".retries")
+		}
+		channels[name] = conf
+	}
+	return channels
+}
+
 type Config struct {
-	Timezone      string          `yaml:"timezone"`
-	SendInterval  uint16          `yaml:"send_interval"`
-	FetchInterval uint16          `yaml:"fetch_interval"`
-	Database      *DatabaseConfig `yaml:"database"`
-	Mailbox       *MailboxConfig  `yaml:"mailbox"`
-	IMAP          *ServerConfig   `yaml:"imap"`
-	SMTP          *ServerConfig   `yaml:"smtp"`
+	Timezone      string                    `yaml:"timezone"`
+	SendInterval  uint16                    `yaml:"send_interval"`
+	FetchInterval uint16                    `yaml:"fetch_interval"`
+	Confirm       bool                      `yaml:"confirm"`
+	Database      *DatabaseConfig           `yaml:"database"`
+	Mailbox       *MailboxConfig            `yaml:"mailbox"`
+	Senders       *SendersConfig            `yaml:"senders"`
+	Recipients    *RecipientsConfig         `yaml:"recipients"`
+	Auth          *AuthConfig               `yaml:"auth"`
+	Limits        *LimitsConfig             `yaml:"limits"`
+	Calendar      *CalendarConfig           `yaml:"calendar"`
+	Webhook       *WebhookConfig            `yaml:"webhook"`
+	Channels      map[string]*ChannelConfig `yaml:"channels"`
+	HTTP          *HTTPConfig               `yaml:"http"`
+	IMAP          *ServerConfig             `yaml:"imap"`
+	SMTP          *ServerConfig             `yaml:"smtp"`
 }
 
 func (conf *Config) Location() *time.Location {
@@ -117,14 +423,37 @@ func (conf *Config) Location() *time.Location {
 	return loc
 }
 
+// MissingKeys lets the keys GetConfig requires be reported instead of fatal.
+func MissingKeys() []string {
+	required := []string{"timezone", "send_interval", "fetch_interval", "database.url", "mailbox.in", "mailbox.processed"}
+	required = append(required, serverKeys("imap")...)
+	required = append(required, serverKeys("smtp")...)
+	missing := make([]string, 0)
+	for _, key := range required {
+		if !viper.IsSet(key) {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
 func GetConfig() *Config {
 	assertKeys([]string{"timezone", "send_interval", "fetch_interval"})
 	return &Config{
 		Timezone:      viper.GetString("timezone"),
 		SendInterval:  viper.GetUint16("send_interval"),
 		FetchInterval: viper.GetUint16("fetch_interval"),
+		Confirm:       viper.GetBool("confirm"),
 		Database:      GetDatabaseConfig("database"),
 		Mailbox:       GetMailboxConfig("mailbox"),
+		Senders:       GetSendersConfig("senders"),
+		Recipients:    GetRecipientsConfig("recipients"),
+		Auth:          GetAuthConfig("auth"),
+		Limits:        GetLimitsConfig("limits"),
+		Calendar:      GetCalendarConfig("calendar"),
+		Webhook:       GetWebhookConfig("webhook"),
+		Channels:      GetChannelsConfig("channels"),
+		HTTP:          GetHTTPConfig("http"),
 		SMTP:          GetServerConfig("smtp"),
 		IMAP:          GetServerConfig("imap"),
 	}
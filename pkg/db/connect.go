@@ -2,13 +2,19 @@ package db
 
 import (
 	"context"
+	"errors"
 
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var ErrRequiresPostgres = errors.New("this feature requires a PostgreSQL database, not SQLite")
+
 func NewPool(ctx context.Context, dbUrl string) (*pgxpool.Pool, error) {
+	if IsSQLite(dbUrl) {
+		return nil, ErrRequiresPostgres
+	}
 	dbConfig, err := pgxpool.ParseConfig(dbUrl)
 	if err != nil {
 		return nil, err
@@ -23,3 +29,15 @@ func NewPool(ctx context.Context, dbUrl string) (*pgxpool.Pool, error) {
 	}
 	return connPool, nil
 }
+
+func WithTx(ctx context.Context, pool *pgxpool.Pool, f func(tx pgx.Tx) error) error {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -3,69 +3,285 @@ package db
 import (
 	"context"
 	"embed"
+	"fmt"
 	"io/fs"
-	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 
-	pgx4 "github.com/jackc/pgx/v4"
-	"github.com/jackc/tern/migrate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 )
 
 //go:embed migrations/*.sql
 var migrations embed.FS
 
+// versionTable has the layout of tern, which managed migrations before.
 const versionTable = "public.version"
-const targetVersion = 1
 
-type EmbeddedMigratorFS struct {
-	fs *embed.FS
+// migrationLock is the advisory lock key tern used.
+const migrationLock = int64(9628173550095224)
+
+const migrationSeparator = "---- create above / drop below ----"
+
+type Migration struct {
+	Sequence int32
+	Name     string
+	UpSQL    string
+	DownSQL  string
+}
+
+type MigrationError struct {
+	Name      string
+	Direction string
+	Err       error
+}
+
+func (err *MigrationError) Error() string {
+	return fmt.Sprintf("migration %s (%s): %s", err.Name, err.Direction, err.Err)
 }
 
-func (m *EmbeddedMigratorFS) ReadDir(dirname string) ([]os.FileInfo, error) {
-	entries, err := m.fs.ReadDir(dirname)
+func (err *MigrationError) Unwrap() error {
+	return err.Err
+}
+
+// Files are named <sequence>_<name>.sql, with sequences starting at 1 without gaps.
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	paths, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
 	if err != nil {
 		return nil, err
 	}
-	infos := make([]os.FileInfo, 0, len(entries))
-	for _, entry := range entries {
-		info, err := entry.Info()
+	list := make([]*Migration, 0, len(paths))
+	for _, p := range paths {
+		name := path.Base(p)
+		prefix, _, _ := strings.Cut(name, "_")
+		sequence, err := strconv.ParseInt(prefix, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no sequence number", name)
+		}
+		body, err := fs.ReadFile(fsys, p)
 		if err != nil {
 			return nil, err
 		}
-		infos = append(infos, info)
+		up, down, _ := strings.Cut(string(body), migrationSeparator)
+		list = append(list, &Migration{
+			Sequence: int32(sequence),
+			Name:     name,
+			UpSQL:    strings.TrimSpace(up),
+			DownSQL:  strings.TrimSpace(down),
+		})
 	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Sequence < list[j].Sequence })
+	for i, m := range list {
+		if m.Sequence != int32(i+1) {
+			return nil, fmt.Errorf("migration %s is out of sequence, expected %d", m.Name, i+1)
+		}
+	}
+	return list, nil
+}
 
-	return infos, nil
+type migrator interface {
+	current(ctx context.Context) (int32, error)
+	migrateTo(ctx context.Context, list []*Migration, version int32) error
+	close()
 }
 
-func (m *EmbeddedMigratorFS) ReadFile(filename string) ([]byte, error) {
-	return m.fs.ReadFile(filename)
+// step returns the next migration towards a version, its SQL and direction,
+// and the version it leads to.
+func step(list []*Migration, current int32, version int32) (*Migration, string, string, int32, error) {
+	if current < 0 || current > int32(len(list)) {
+		return nil, "", "", 0, fmt.Errorf("current version %d is newer than the latest migration %d", current, len(list))
+	}
+	if current < version {
+		return list[current], list[current].UpSQL, "up", current + 1, nil
+	}
+	migration := list[current-1]
+	if migration.DownSQL == "" {
+		return migration, "", "down", 0, fmt.Errorf("migration is irreversible")
+	}
+	return migration, migration.DownSQL, "down", current - 1, nil
 }
 
-func (m *EmbeddedMigratorFS) Glob(pattern string) ([]string, error) {
-	return fs.Glob(m.fs, pattern)
+type Migrations struct {
+	migrator migrator
+	list     []*Migration
 }
 
-func NewMigrator(ctx context.Context, conn *pgx4.Conn, versionTable string) (*migrate.Migrator, error) {
-	return migrate.NewMigratorEx(
-		ctx, conn, versionTable,
-		&migrate.MigratorOptions{
-			DisableTx:  false,
-			MigratorFS: &EmbeddedMigratorFS{&migrations},
-		})
+func OpenMigrations(ctx context.Context, databaseURL string) (*Migrations, error) {
+	if IsSQLite(databaseURL) {
+		list, err := loadMigrations(sqliteMigrations, "sqlite")
+		if err != nil {
+			return nil, err
+		}
+		sqlDB, err := OpenSQLite(ctx, databaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return &Migrations{&sqliteMigrator{sqlDB}, list}, nil
+	}
+	list, err := loadMigrations(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	pool, err := NewPool(ctx, databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrations{&postgresMigrator{pool}, list}, nil
+}
+
+func (m *Migrations) Close() {
+	m.migrator.close()
+}
+
+func (m *Migrations) List() []*Migration {
+	return m.list
+}
+
+func (m *Migrations) Target() int32 {
+	return int32(len(m.list))
+}
+
+func (m *Migrations) Current(ctx context.Context) (int32, error) {
+	return m.migrator.current(ctx)
+}
+
+func (m *Migrations) MigrateTo(ctx context.Context, version int32) error {
+	if version < 0 || version > m.Target() {
+		return fmt.Errorf("version %d is not between 0 and %d", version, m.Target())
+	}
+	return m.migrator.migrateTo(ctx, m.list, version)
+}
+
+type postgresMigrator struct {
+	pool *pgxpool.Pool
+}
+
+func (p *postgresMigrator) current(ctx context.Context) (int32, error) {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	return currentVersion(ctx, conn.Conn())
+}
+
+func currentVersion(ctx context.Context, conn *pgx.Conn) (int32, error) {
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, versionTable).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+	var version int32
+	err := conn.QueryRow(ctx, `SELECT version FROM `+versionTable).Scan(&version)
+	return version, err
+}
+
+func (p *postgresMigrator) migrateTo(ctx context.Context, list []*Migration, version int32) error {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS `+versionTable+` (version int4 NOT NULL);
+		INSERT INTO `+versionTable+` (version)
+			SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM `+versionTable+`);
+	`); err != nil {
+		return err
+	}
+	// Read under the lock, so that concurrent migrations are not applied twice.
+	current, err := currentVersion(ctx, conn.Conn())
+	if err != nil {
+		return err
+	}
+	for current != version {
+		migration, sql, direction, next, err := step(list, current, version)
+		if err == nil {
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, sql); err != nil {
+					return err
+				}
+				// Migrations may change settings such as the search path.
+				if _, err := tx.Exec(ctx, `RESET ALL`); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `UPDATE `+versionTable+` SET version = $1`, next)
+				return err
+			})
+		}
+		if err != nil {
+			if migration != nil {
+				return &MigrationError{migration.Name, direction, err}
+			}
+			return err
+		}
+		current = next
+	}
+	return nil
+}
+
+func (p *postgresMigrator) close() {
+	p.pool.Close()
 }
 
 func Migrate(ctx context.Context, databaseURL string) error {
-	conn, err := pgx4.Connect(ctx, databaseURL)
+	m, err := OpenMigrations(ctx, databaseURL)
 	if err != nil {
 		return err
 	}
-	defer conn.Close(ctx)
-	migrator, err := NewMigrator(ctx, conn, versionTable)
+	defer m.Close()
+	log.Info().Msgf("migrating database to version %d", m.Target())
+	return m.MigrateTo(ctx, m.Target())
+}
+
+func Status(ctx context.Context, databaseURL string) (int32, int32, error) {
+	m, err := OpenMigrations(ctx, databaseURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer m.Close()
+	current, err := m.Current(ctx)
+	return current, m.Target(), err
+}
+
+type SchemaError struct {
+	Current int32
+	Target  int32
+}
+
+func (err *SchemaError) Error() string {
+	if err.Current < err.Target {
+		return fmt.Sprintf(
+			"database schema is at version %d, but version %d is required: run mxremind migrate, or start with --migrate",
+			err.Current, err.Target,
+		)
+	}
+	return fmt.Sprintf(
+		"database schema is at version %d, which is newer than version %d of this mxremind",
+		err.Current, err.Target,
+	)
+}
+
+// A newer schema is only logged, so that older versions still run during upgrades.
+func CheckSchema(ctx context.Context, databaseURL string) error {
+	current, target, err := Status(ctx, databaseURL)
 	if err != nil {
 		return err
 	}
-	migrator.LoadMigrations("migrations")
-	log.Info().Msgf("migrating database to version %d", targetVersion)
-	return migrator.MigrateTo(ctx, targetVersion)
+	if current > target {
+		log.Warn().Err(&SchemaError{current, target}).Msg("")
+	} else if current < target {
+		return &SchemaError{current, target}
+	}
+	return nil
 }
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := loadMigrations(migrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, m := range list {
+		if m.Sequence != int32(i+1) || m.UpSQL == "" || m.DownSQL == "" {
+			t.Errorf("migration %d: got %d %s, up %d bytes, down %d bytes", i+1, m.Sequence, m.Name, len(m.UpSQL), len(m.DownSQL))
+		}
+	}
+
+	fsys := fstest.MapFS{
+		"m/001_a.sql": {Data: []byte("CREATE TABLE a ();\n" + migrationSeparator + "\nDROP TABLE a;\n")},
+		"m/003_c.sql": {Data: []byte("CREATE TABLE c ();")},
+	}
+	if _, err := loadMigrations(fsys, "m"); err == nil {
+		t.Error("expected an error for a gap in sequences")
+	}
+	delete(fsys, "m/003_c.sql")
+	list, err = loadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].UpSQL != "CREATE TABLE a ();" || list[0].DownSQL != "DROP TABLE a;" {
+		t.Errorf("got up %q, down %q", list[0].UpSQL, list[0].DownSQL)
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	m, err := OpenMigrations(ctx, "sqlite://"+filepath.Join(t.TempDir(), "mxremind.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for _, version := range []int32{m.Target(), 0, m.Target()} {
+		if err := m.MigrateTo(ctx, version); err != nil {
+			t.Fatal(err)
+		}
+		if current, err := m.Current(ctx); err != nil || current != version {
+			t.Errorf("got version %d, expected %d (%v)", current, version, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+ALTER TABLE reminders
+    ADD COLUMN escalate_to TEXT NOT NULL DEFAULT '',
+    ADD COLUMN escalate_after INTERVAL NOT NULL DEFAULT '0',
+    ADD COLUMN is_acknowledged BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN is_escalated BOOLEAN NOT NULL DEFAULT false;
+
+---- create above / drop below ----
+
+ALTER TABLE reminders
+    DROP COLUMN is_escalated,
+    DROP COLUMN is_acknowledged,
+    DROP COLUMN escalate_after,
+    DROP COLUMN escalate_to;
@@ -0,0 +1,9 @@
+CREATE TABLE reminder_recipients (
+    reminder_id UUID NOT NULL REFERENCES reminders (id) ON DELETE CASCADE,
+    recipient TEXT NOT NULL,
+    PRIMARY KEY (reminder_id, recipient)
+);
+
+---- create above / drop below ----
+
+DROP TABLE reminder_recipients;
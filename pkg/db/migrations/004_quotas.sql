@@ -0,0 +1,32 @@
+ALTER TABLE reminders
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC');
+
+CREATE INDEX reminders_recipient_idx ON reminders (lower(recipient), created_at);
+
+ALTER TABLE users
+    ADD COLUMN max_active_reminders INTEGER,
+    ADD COLUMN max_reminders_per_hour INTEGER,
+    ADD COLUMN max_mails_per_day INTEGER;
+
+CREATE TABLE deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    reminder_id UUID,
+    recipient TEXT NOT NULL,
+    sent_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX deliveries_recipient_idx ON deliveries (lower(recipient), sent_at);
+
+---- create above / drop below ----
+
+DROP TABLE deliveries;
+
+ALTER TABLE users
+    DROP COLUMN max_mails_per_day,
+    DROP COLUMN max_reminders_per_hour,
+    DROP COLUMN max_active_reminders;
+
+DROP INDEX reminders_recipient_idx;
+
+ALTER TABLE reminders
+    DROP COLUMN created_at;
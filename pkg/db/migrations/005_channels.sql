@@ -0,0 +1,13 @@
+ALTER TABLE users
+    ADD COLUMN channel TEXT NOT NULL DEFAULT 'email';
+
+ALTER TABLE deliveries
+    ADD COLUMN channel TEXT NOT NULL DEFAULT 'email';
+
+---- create above / drop below ----
+
+ALTER TABLE deliveries
+    DROP COLUMN channel;
+
+ALTER TABLE users
+    DROP COLUMN channel;
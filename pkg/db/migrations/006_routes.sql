@@ -0,0 +1,18 @@
+ALTER TABLE reminders
+    ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN priority TEXT NOT NULL DEFAULT 'normal';
+
+CREATE TABLE routes (
+    email TEXT NOT NULL,
+    match TEXT NOT NULL,
+    channels TEXT[] NOT NULL,
+    PRIMARY KEY (email, match)
+);
+
+---- create above / drop below ----
+
+DROP TABLE routes;
+
+ALTER TABLE reminders
+    DROP COLUMN priority,
+    DROP COLUMN tags;
@@ -0,0 +1,21 @@
+ALTER TABLE users
+    ADD COLUMN digest TEXT NOT NULL DEFAULT '' CHECK (digest IN ('', 'daily', 'weekly')),
+    ADD COLUMN digest_time TIME NOT NULL DEFAULT '08:00',
+    ADD COLUMN digest_weekday INTEGER NOT NULL DEFAULT 1 CHECK (digest_weekday BETWEEN 0 AND 6),
+    ADD COLUMN last_digest_at TIMESTAMP;
+
+ALTER TABLE reminders
+    ADD COLUMN is_digested BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN awaits_digest BOOLEAN NOT NULL DEFAULT false;
+
+---- create above / drop below ----
+
+ALTER TABLE reminders
+    DROP COLUMN awaits_digest,
+    DROP COLUMN is_digested;
+
+ALTER TABLE users
+    DROP COLUMN last_digest_at,
+    DROP COLUMN digest_weekday,
+    DROP COLUMN digest_time,
+    DROP COLUMN digest;
@@ -0,0 +1,27 @@
+ALTER TABLE users
+    ADD COLUMN quiet_start TIME,
+    ADD COLUMN quiet_end TIME,
+    ADD COLUMN quiet_weekends BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE reminders
+    ADD COLUMN held_until TIMESTAMP;
+
+CREATE TABLE held_deliveries (
+    reminder_id UUID NOT NULL REFERENCES reminders (id) ON DELETE CASCADE,
+    recipient TEXT NOT NULL,
+    is_escalation BOOLEAN NOT NULL,
+    held_until TIMESTAMP NOT NULL,
+    PRIMARY KEY (reminder_id, recipient, is_escalation)
+);
+
+---- create above / drop below ----
+
+DROP TABLE held_deliveries;
+
+ALTER TABLE reminders
+    DROP COLUMN held_until;
+
+ALTER TABLE users
+    DROP COLUMN quiet_weekends,
+    DROP COLUMN quiet_end,
+    DROP COLUMN quiet_start;
@@ -0,0 +1,17 @@
+ALTER TABLE reminders
+    ADD COLUMN is_cancelled BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE api_tokens (
+    token_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    is_admin BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+);
+
+---- create above / drop below ----
+
+DROP TABLE api_tokens;
+
+ALTER TABLE reminders
+    DROP COLUMN is_cancelled;
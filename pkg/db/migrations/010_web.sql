@@ -0,0 +1,17 @@
+CREATE TABLE login_tokens (
+    token_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE sessions (
+    session_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+---- create above / drop below ----
+
+DROP TABLE sessions;
+
+DROP TABLE login_tokens;
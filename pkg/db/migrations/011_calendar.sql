@@ -0,0 +1,11 @@
+ALTER TABLE reminders
+    ADD COLUMN is_invited BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN is_invite_cancelled BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+
+---- create above / drop below ----
+
+ALTER TABLE reminders
+    DROP COLUMN revision,
+    DROP COLUMN is_invite_cancelled,
+    DROP COLUMN is_invited;
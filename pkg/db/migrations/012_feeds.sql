@@ -0,0 +1,9 @@
+CREATE TABLE feed_tokens (
+    token_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+);
+
+---- create above / drop below ----
+
+DROP TABLE feed_tokens;
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const MinPrefixLength = 6
+
+var likeEscapes = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// LikePrefix escapes the wildcards of prefix.
+func LikePrefix(prefix string) string {
+	return likeEscapes.Replace(prefix) + "%"
+}
+
+// DeleteByHashPrefix fails unless exactly one token_hash starts with id.
+func DeleteByHashPrefix(ctx context.Context, tx pgx.Tx, table string, kind string, id string) error {
+	if len(id) < MinPrefixLength {
+		return fmt.Errorf("%s id %q is too short", kind, id)
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE token_hash LIKE $1`, LikePrefix(id))
+	if err != nil {
+		return err
+	}
+	switch tag.RowsAffected() {
+	case 0:
+		return fmt.Errorf("no %s %q", kind, id)
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("%s id %q is ambiguous", kind, id)
+	}
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLikePrefix(t *testing.T) {
+	cases := map[string]string{
+		"abc123": "abc123%",
+		"ab%_cd": `ab\%\_cd%`,
+		`ab\cd`:  `ab\\cd%`,
+	}
+	for prefix, expected := range cases {
+		if pattern := LikePrefix(prefix); pattern != expected {
+			t.Errorf("%s: got %q, expected %q", prefix, pattern, expected)
+		}
+	}
+	if err := DeleteByHashPrefix(context.Background(), nil, "api_tokens", "token", "%%%"); err == nil {
+		t.Error("expected error for a short id")
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"strings"
+
+	// Registers the pure Go "sqlite" driver.
+	_ "modernc.org/sqlite"
+)
+
+//go:embed sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// sqliteScheme URLs look like sqlite:///var/lib/mxremind/mxremind.db or sqlite:mxremind.db.
+const sqliteScheme = "sqlite:"
+
+func IsSQLite(databaseURL string) bool {
+	return strings.HasPrefix(databaseURL, sqliteScheme)
+}
+
+func sqlitePath(databaseURL string) string {
+	path := strings.TrimPrefix(databaseURL, sqliteScheme)
+	if strings.HasPrefix(path, "//") {
+		path = path[2:]
+	}
+	return path
+}
+
+// Transactions take the write lock when they begin, so that writers wait for
+// each other instead of failing.
+func OpenSQLite(ctx context.Context, databaseURL string) (*sql.DB, error) {
+	if !IsSQLite(databaseURL) {
+		return nil, fmt.Errorf("not a SQLite database URL: %q", databaseURL)
+	}
+	dsn := sqlitePath(databaseURL) +
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection avoids busy errors. Transactions must not span network
+	// I/O, which would block every other component.
+	sqlDB.SetMaxOpenConns(1)
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return sqlDB, nil
+}
+
+// The schema version is stored in the user_version pragma.
+type sqliteMigrator struct {
+	db *sql.DB
+}
+
+func (s *sqliteMigrator) current(ctx context.Context) (int32, error) {
+	var version int32
+	err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+func (s *sqliteMigrator) migrateTo(ctx context.Context, list []*Migration, version int32) error {
+	for {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		var current int32
+		if err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&current); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if current == version {
+			return tx.Rollback()
+		}
+		migration, sql, direction, next, err := step(list, current, version)
+		if err == nil {
+			if _, err = tx.ExecContext(ctx, sql); err == nil {
+				_, err = tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, next))
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			if migration != nil {
+				return &MigrationError{migration.Name, direction, err}
+			}
+			return err
+		}
+	}
+}
+
+func (s *sqliteMigrator) close() {
+	s.db.Close()
+}
@@ -0,0 +1,54 @@
+-- Times are Unix timestamps in seconds, and durations are in nanoseconds.
+CREATE TABLE reminders (
+    id TEXT PRIMARY KEY,
+    generated_from_id TEXT NOT NULL DEFAULT '',
+    recipient TEXT NOT NULL,
+    content TEXT NOT NULL,
+    due_time INTEGER NOT NULL,
+    is_sent INTEGER NOT NULL DEFAULT 0,
+    escalate_to TEXT NOT NULL DEFAULT '',
+    escalate_after INTEGER NOT NULL DEFAULT 0,
+    is_acknowledged INTEGER NOT NULL DEFAULT 0,
+    is_escalated INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+    -- Tags are separated by spaces.
+    tags TEXT NOT NULL DEFAULT '',
+    priority TEXT NOT NULL DEFAULT 'normal',
+    is_digested INTEGER NOT NULL DEFAULT 0,
+    held_until INTEGER,
+    is_cancelled INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX reminders_due_time_idx ON reminders (due_time) WHERE NOT is_sent;
+
+CREATE INDEX reminders_recipient_idx ON reminders (lower(recipient), created_at);
+
+CREATE TABLE reminder_recipients (
+    reminder_id TEXT NOT NULL REFERENCES reminders (id) ON DELETE CASCADE,
+    recipient TEXT NOT NULL,
+    PRIMARY KEY (reminder_id, recipient)
+);
+
+CREATE TABLE deliveries (
+    reminder_id TEXT,
+    recipient TEXT NOT NULL,
+    channel TEXT NOT NULL DEFAULT 'email',
+    sent_at INTEGER NOT NULL
+);
+
+CREATE INDEX deliveries_recipient_idx ON deliveries (lower(recipient), sent_at);
+
+CREATE TABLE held_deliveries (
+    reminder_id TEXT NOT NULL REFERENCES reminders (id) ON DELETE CASCADE,
+    recipient TEXT NOT NULL,
+    is_escalation BOOLEAN NOT NULL,
+    held_until INTEGER NOT NULL,
+    PRIMARY KEY (reminder_id, recipient, is_escalation)
+);
+
+---- create above / drop below ----
+
+DROP TABLE held_deliveries;
+DROP TABLE deliveries;
+DROP TABLE reminder_recipients;
+DROP TABLE reminders;
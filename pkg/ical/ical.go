@@ -0,0 +1,243 @@
+// Package ical reads and writes events of iCalendar (RFC 5545) data.
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+type Event struct {
+	UID       string
+	Summary   string
+	Start     time.Time
+	End       time.Time
+	AllDay    bool
+	Alarms    []time.Time
+	Organizer string
+	Attendees []string
+	Sequence  int
+	Method    string
+	Status    string
+	duration  time.Duration
+}
+
+func (e *Event) IsCancelled() bool {
+	return e.Method == MethodCancel || e.Status == "CANCELLED"
+}
+
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+func unfold(data []byte) []string {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+		} else if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parameter values may be quoted, and contain colons if they are.
+func parseProperty(line string) (*Property, error) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return nil, fmt.Errorf("invalid content line %q", line)
+	}
+	parts := strings.Split(line[:colon], ";")
+	p := &Property{
+		Name:   strings.ToUpper(parts[0]),
+		Params: make(map[string]string),
+		Value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		p.Params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+	return p, nil
+}
+
+var textEscapes = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func (p *Property) Text() string {
+	return textEscapes.Replace(p.Value)
+}
+
+// Times without a timezone are in loc; an unknown TZID is an error.
+func (p *Property) Time(loc *time.Location) (t time.Time, allDay bool, err error) {
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if loc, err = LoadLocation(tzid); err != nil {
+			return t, false, err
+		}
+	}
+	switch {
+	case p.Params["VALUE"] == "DATE" || len(p.Value) == 8:
+		t, err = time.ParseInLocation("20060102", p.Value, loc)
+		return t, true, err
+	case strings.HasSuffix(p.Value, "Z"):
+		t, err = time.Parse("20060102T150405Z", p.Value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", p.Value, loc)
+	}
+	return t, false, err
+}
+
+var regexDuration = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func ParseDuration(s string) (time.Duration, error) {
+	match := regexDuration.FindStringSubmatch(strings.ToUpper(s))
+	if match == nil || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+2] != "" {
+			n, _ := strconv.Atoi(match[i+2])
+			d += time.Duration(n) * unit
+		}
+	}
+	if match[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+type trigger struct {
+	At      time.Time
+	Offset  time.Duration
+	FromEnd bool
+}
+
+// Recurrences are ignored: only the first occurrence of an event is returned.
+func Parse(data []byte, loc *time.Location) ([]*Event, error) {
+	events := make([]*Event, 0)
+	method := ""
+	var event *Event
+	var triggers []*trigger
+	var alarm *trigger
+	inAlarm := false
+	for _, line := range unfold(data) {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VEVENT"):
+			event = &Event{Method: method, Alarms: make([]time.Time, 0)}
+			triggers = nil
+		case p.Name == "END" && strings.EqualFold(p.Value, "VEVENT") && event != nil:
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("event %q has no DTSTART", event.UID)
+			}
+			if event.End.IsZero() {
+				event.End = event.Start.Add(event.duration)
+			}
+			for _, t := range triggers {
+				switch {
+				case !t.At.IsZero():
+					event.Alarms = append(event.Alarms, t.At)
+				case t.FromEnd:
+					event.Alarms = append(event.Alarms, event.End.Add(t.Offset))
+				default:
+					event.Alarms = append(event.Alarms, event.Start.Add(t.Offset))
+				}
+			}
+			events = append(events, event)
+			event = nil
+		case event == nil:
+			if p.Name == "METHOD" {
+				method = strings.ToUpper(p.Value)
+			}
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VALARM"):
+			inAlarm = true
+			alarm = nil
+		case p.Name == "END" && strings.EqualFold(p.Value, "VALARM"):
+			inAlarm = false
+			if alarm != nil {
+				triggers = append(triggers, alarm)
+			}
+		case inAlarm:
+			if p.Name == "TRIGGER" {
+				if alarm, err = parseTrigger(p, loc); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			if err := event.set(p, loc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return events, nil
+}
+
+func parseTrigger(p *Property, loc *time.Location) (*trigger, error) {
+	if p.Params["VALUE"] == "DATE-TIME" {
+		at, _, err := p.Time(loc)
+		return &trigger{At: at}, err
+	}
+	offset, err := ParseDuration(p.Value)
+	return &trigger{Offset: offset, FromEnd: p.Params["RELATED"] == "END"}, err
+}
+
+func mailto(uri string) string {
+	if len(uri) > 7 && strings.EqualFold(uri[:7], "mailto:") {
+		return uri[7:]
+	}
+	return uri
+}
+
+func (e *Event) set(p *Property, loc *time.Location) error {
+	var err error
+	switch p.Name {
+	case "UID":
+		e.UID = p.Value
+	case "SUMMARY":
+		e.Summary = p.Text()
+	case "STATUS":
+		e.Status = strings.ToUpper(p.Value)
+	case "SEQUENCE":
+		e.Sequence, err = strconv.Atoi(p.Value)
+	case "ORGANIZER":
+		e.Organizer = mailto(p.Value)
+	case "ATTENDEE":
+		e.Attendees = append(e.Attendees, mailto(p.Value))
+	case "DTSTART":
+		e.Start, e.AllDay, err = p.Time(loc)
+	case "DTEND":
+		e.End, _, err = p.Time(loc)
+	case "DURATION":
+		e.duration, err = ParseDuration(p.Value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", p.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInvite = "BEGIN:VCALENDAR\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Paris\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:abc@example.com\r\n" +
+	"SUMMARY:Team sync\\, weekly\r\n" +
+	"DTSTART;TZID=Europe/Paris:20230406T150000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"DESCRIPTION:A long description which is folded over\r\n" +
+	"  two lines\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT10M\r\n" +
+	"END:VALARM\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER;RELATED=END:PT0S\r\n" +
+	"END:VALARM\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER;VALUE=DATE-TIME:20230405T080000Z\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:def@example.com\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"DTSTART;VALUE=DATE:20230410\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Parse([]byte(testInvite), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events", len(events))
+	}
+	sync := events[0]
+	start := time.Date(2023, 4, 6, 15, 0, 0, 0, paris)
+	if sync.UID != "abc@example.com" || sync.Summary != "Team sync, weekly" || sync.Method != MethodRequest {
+		t.Errorf("got %+v", sync)
+	}
+	if !sync.Start.Equal(start) || !sync.End.Equal(start.Add(time.Hour)) || sync.AllDay {
+		t.Errorf("got start %s, end %s", sync.Start, sync.End)
+	}
+	alarms := []time.Time{start.Add(-10 * time.Minute), start.Add(time.Hour), time.Date(2023, 4, 5, 8, 0, 0, 0, time.UTC)}
+	if len(sync.Alarms) != len(alarms) {
+		t.Fatalf("got alarms %v", sync.Alarms)
+	}
+	for i, alarm := range alarms {
+		if !sync.Alarms[i].Equal(alarm) {
+			t.Errorf("alarm %d: got %s, expected %s", i, sync.Alarms[i], alarm)
+		}
+	}
+	holiday := events[1]
+	if !holiday.AllDay || !holiday.IsCancelled() || !holiday.Start.Equal(time.Date(2023, 4, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v", holiday)
+	}
+}
+
+const testOutlookInvite = "BEGIN:VCALENDAR\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"PRODID:Microsoft Exchange Server 2010\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Eastern Standard Time\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:-0400\r\n" +
+	"TZOFFSETTO:-0500\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=1SU;BYMONTH=11\r\n" +
+	"END:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:-0500\r\n" +
+	"TZOFFSETTO:-0400\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=2SU;BYMONTH=3\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:040000008200E00074C5B7101A82E008\r\n" +
+	"SUMMARY;LANGUAGE=en-US:Quarterly review\r\n" +
+	"DTSTART;TZID=Eastern Standard Time:20230406T150000\r\n" +
+	"DTEND;TZID=Eastern Standard Time:20230406T160000\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseTimezones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Parse([]byte(testOutlookInvite), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 4, 6, 15, 0, 0, 0, newYork)
+	if len(events) != 1 || !events[0].Start.Equal(start) || !events[0].End.Equal(start.Add(time.Hour)) {
+		t.Errorf("got %+v, expected a start at %s", events[0], start)
+	}
+
+	unknown := strings.ReplaceAll(testOutlookInvite, "Eastern Standard Time", "Customized Time Zone")
+	if _, err := Parse([]byte(unknown), time.UTC); err == nil || !strings.Contains(err.Error(), "Customized Time Zone") {
+		t.Errorf("got %v, expected an unknown TZID error", err)
+	}
+
+	for windows, iana := range windowsZones {
+		if _, err := LoadLocation(windows); err != nil {
+			t.Errorf("%s: %s is not a known location", windows, iana)
+		}
+	}
+	for _, tzid := range []string{"/Europe/Paris", `"Europe/Paris"`} {
+		if loc, err := LoadLocation(tzid); err != nil || loc.String() != "Europe/Paris" {
+			t.Errorf("%s: got %v (%v)", tzid, loc, err)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"PT15M":      15 * time.Minute,
+		"-PT15M":     -15 * time.Minute,
+		"+P1DT12H":   36 * time.Hour,
+		"P1W":        7 * 24 * time.Hour,
+		"-PT1H30M5S": -(time.Hour + 30*time.Minute + 5*time.Second),
+	}
+	for s, expected := range cases {
+		if d, err := ParseDuration(s); err != nil || d != expected {
+			t.Errorf("%s: got %s (%v), expected %s", s, d, err, expected)
+		}
+	}
+	for _, s := range []string{"", "P", "PT", "15M", "P1H"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	start := time.Date(2023, 4, 6, 15, 0, 0, 0, time.UTC)
+	event := &Event{
+		UID:       "abc@mxremind",
+		Summary:   "Call Bob, about the very long and detailed quarterly report; then send it",
+		Start:     start,
+		End:       start,
+		Alarms:    []time.Time{start},
+		Organizer: "reminders@example.com",
+		Attendees: []string{"alice@example.com"},
+	}
+	data := Write(MethodRequest, event, start.Add(-time.Hour))
+	for _, line := range bytes.Split(data, []byte("\r\n")) {
+		if len(line) > maxLineLength+1 {
+			t.Errorf("line is not folded: %q", line)
+		}
+	}
+	events, err := Parse(data, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events", len(events))
+	}
+	got := events[0]
+	if got.UID != event.UID || got.Summary != event.Summary || !got.Start.Equal(start) || got.Method != MethodRequest ||
+		got.Organizer != event.Organizer || len(got.Attendees) != 1 || got.Attendees[0] != "alice@example.com" {
+		t.Errorf("got %+v", got)
+	}
+	if len(got.Alarms) != 1 || !got.Alarms[0].Equal(start) {
+		t.Errorf("got alarms %v", got.Alarms)
+	}
+
+	event.Sequence = 1
+	events, err = Parse(Write(MethodCancel, event, start), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := events[0]; !got.IsCancelled() || got.Sequence != 1 || len(got.Alarms) != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,99 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const ProdId = "-//MxRemind//MxRemind//EN"
+
+// maxLineLength is in octets.
+const maxLineLength = 75
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func writeLine(buf *bytes.Buffer, line string) {
+	for len(line) > maxLineLength {
+		cut := maxLineLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Published calendars have no Method.
+type Calendar struct {
+	Method string
+	Name   string
+	Events []*Event
+}
+
+func Write(method string, e *Event, stamp time.Time) []byte {
+	return (&Calendar{Method: method, Events: []*Event{e}}).Write(stamp)
+}
+
+// Times are written in UTC, and alarms with absolute times.
+func (c *Calendar) Write(stamp time.Time) []byte {
+	var buf bytes.Buffer
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + ProdId,
+	}
+	if c.Method != "" {
+		lines = append(lines, "METHOD:"+c.Method)
+	}
+	if c.Name != "" {
+		lines = append(lines, "X-WR-CALNAME:"+textEscaper.Replace(c.Name))
+	}
+	for _, e := range c.Events {
+		lines = append(lines, e.lines(c.Method, stamp)...)
+	}
+	lines = append(lines, "END:VCALENDAR")
+	for _, line := range lines {
+		writeLine(&buf, line)
+	}
+	return buf.Bytes()
+}
+
+func (e *Event) lines(method string, stamp time.Time) []string {
+	lines := []string{
+		"BEGIN:VEVENT",
+		"UID:" + e.UID,
+		"DTSTAMP:" + formatTime(stamp),
+		"DTSTART:" + formatTime(e.Start),
+		"DTEND:" + formatTime(e.End),
+		"SUMMARY:" + textEscaper.Replace(e.Summary),
+		fmt.Sprintf("SEQUENCE:%d", e.Sequence),
+	}
+	if e.Organizer != "" {
+		lines = append(lines, "ORGANIZER:mailto:"+e.Organizer)
+	}
+	for _, attendee := range e.Attendees {
+		lines = append(lines, "ATTENDEE;RSVP=FALSE:mailto:"+attendee)
+	}
+	if method == MethodCancel {
+		lines = append(lines, "STATUS:CANCELLED")
+	} else {
+		for _, alarm := range e.Alarms {
+			lines = append(lines,
+				"BEGIN:VALARM",
+				"ACTION:DISPLAY",
+				"DESCRIPTION:"+textEscaper.Replace(e.Summary),
+				"TRIGGER;VALUE=DATE-TIME:"+formatTime(alarm),
+				"END:VALARM",
+			)
+		}
+	}
+	return append(lines, "END:VEVENT")
+}
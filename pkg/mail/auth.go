@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	AuthDKIM  = "dkim"
+	AuthSPF   = "spf"
+	AuthDMARC = "dmarc"
+)
+
+type Authenticator struct {
+	// If empty, only the topmost Authentication-Results header is trusted.
+	TrustedServers []string
+	VerifyDKIM     bool
+	// By record name; if not empty, DNS is not queried.
+	DKIMKeys map[string]string
+}
+
+func NewAuthenticator(conf *config.AuthConfig) *Authenticator {
+	return &Authenticator{
+		TrustedServers: conf.TrustedServers,
+		VerifyDKIM:     conf.DKIM.Verify,
+		DKIMKeys:       conf.DKIM.Keys,
+	}
+}
+
+// DKIM results only pass if the signing domain is aligned with the From domain.
+func (a *Authenticator) Authenticate(from string, raw []byte) map[string]string {
+	results := make(map[string]string)
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		log.Warn().Err(err).Msg("error reading message header")
+		return results
+	}
+	fromDomain := domainOf(from)
+	for _, value := range a.trustedResults(msg.Header["Authentication-Results"]) {
+		_, parsed, err := authres.Parse(value)
+		if err != nil {
+			log.Warn().Err(err).Msg("error parsing Authentication-Results")
+			continue
+		}
+		for _, result := range parsed {
+			switch r := result.(type) {
+			case *authres.DKIMResult:
+				if r.Value != authres.ResultPass || aligned(r.Domain, fromDomain) {
+					mergeResult(results, AuthDKIM, string(r.Value))
+				}
+			case *authres.SPFResult:
+				mergeResult(results, AuthSPF, string(r.Value))
+			case *authres.DMARCResult:
+				mergeResult(results, AuthDMARC, string(r.Value))
+			}
+		}
+	}
+	if a.VerifyDKIM {
+		results[AuthDKIM] = a.verifyDKIM(fromDomain, raw)
+	}
+	return results
+}
+
+func (a *Authenticator) trustedResults(values []string) []string {
+	if len(a.TrustedServers) == 0 {
+		if len(values) == 0 {
+			return values
+		}
+		return values[:1]
+	}
+	trusted := make([]string, 0)
+	for _, value := range values {
+		identity := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+		for _, server := range a.TrustedServers {
+			if strings.EqualFold(identity, server) {
+				trusted = append(trusted, value)
+				break
+			}
+		}
+	}
+	return trusted
+}
+
+func (a *Authenticator) verifyDKIM(fromDomain string, raw []byte) string {
+	options := &dkim.VerifyOptions{}
+	if len(a.DKIMKeys) > 0 {
+		options.LookupTXT = func(domain string) ([]string, error) {
+			if key, ok := a.DKIMKeys[strings.ToLower(domain)]; ok {
+				return []string{key}, nil
+			}
+			return nil, fmt.Errorf("no DKIM key for %s", domain)
+		}
+	}
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), options)
+	if err != nil {
+		log.Warn().Err(err).Msg("error verifying DKIM signatures")
+		return string(authres.ResultTempError)
+	}
+	if len(verifications) == 0 {
+		return string(authres.ResultNone)
+	}
+	for _, v := range verifications {
+		if v.Err == nil && aligned(v.Domain, fromDomain) {
+			return string(authres.ResultPass)
+		}
+	}
+	return string(authres.ResultFail)
+}
+
+func mergeResult(results map[string]string, method string, value string) {
+	if results[method] != string(authres.ResultPass) {
+		results[method] = value
+	}
+}
+
+// aligned implements relaxed alignment.
+func aligned(domain string, fromDomain string) bool {
+	domain = strings.ToLower(domain)
+	return domain != "" && (fromDomain == domain || strings.HasSuffix(fromDomain, "."+domain))
+}
+
+func domainOf(address string) string {
+	return strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+}
@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+const testMessage = "From: alice@example.com\r\n" +
+	"To: reminders@example.com\r\n" +
+	"Subject: 15:04 do the thing\r\n" +
+	"\r\n" +
+	"\r\n"
+
+func signMessage(t *testing.T, domain string) ([]byte, map[string]string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, strings.NewReader(testMessage), &dkim.SignOptions{
+		Domain:   domain,
+		Selector: "test",
+		Signer:   priv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{
+		"test._domainkey." + domain: "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+	}
+	return signed.Bytes(), keys
+}
+
+func TestAuthenticateDKIM(t *testing.T) {
+	signed, keys := signMessage(t, "example.com")
+	auth := &Authenticator{VerifyDKIM: true, DKIMKeys: keys}
+	if result := auth.Authenticate("alice@example.com", signed)[AuthDKIM]; result != "pass" {
+		t.Errorf("signed message: got %q", result)
+	}
+
+	tampered := bytes.Replace(signed, []byte("do the thing"), []byte("do the other thing"), 1)
+	if result := auth.Authenticate("alice@example.com", tampered)[AuthDKIM]; result != "fail" {
+		t.Errorf("tampered message: got %q", result)
+	}
+
+	if result := auth.Authenticate("alice@example.com", []byte(testMessage))[AuthDKIM]; result != "none" {
+		t.Errorf("unsigned message: got %q", result)
+	}
+
+	signed, keys = signMessage(t, "evil.com")
+	auth = &Authenticator{VerifyDKIM: true, DKIMKeys: keys}
+	if result := auth.Authenticate("alice@example.com", signed)[AuthDKIM]; result != "fail" {
+		t.Errorf("unaligned signature: got %q", result)
+	}
+}
+
+func TestAuthenticationResults(t *testing.T) {
+	raw := []byte("Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=example.com;\r\n" +
+		" dkim=pass header.d=example.com; dmarc=pass header.from=example.com\r\n" +
+		"Authentication-Results: mx.evil.com; dmarc=fail header.from=example.com\r\n" +
+		testMessage)
+
+	auth := &Authenticator{TrustedServers: []string{"mx.example.com"}}
+	results := auth.Authenticate("alice@example.com", raw)
+	for _, method := range []string{AuthDKIM, AuthSPF, AuthDMARC} {
+		if results[method] != "pass" {
+			t.Errorf("%s: got %q", method, results[method])
+		}
+	}
+
+	auth = &Authenticator{TrustedServers: []string{"mx.evil.com"}}
+	results = auth.Authenticate("alice@example.com", raw)
+	if results[AuthDMARC] != "fail" || results[AuthSPF] != "" {
+		t.Errorf("got %v", results)
+	}
+}
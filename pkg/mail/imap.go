@@ -2,28 +2,68 @@ package mail
 
 import (
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-sasl"
 	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/ical"
 	"github.com/rs/zerolog/log"
 )
 
 func ConnectImap(conf *config.ServerConfig) (*client.Client, error) {
+	mode, err := conf.Tls.GetMode(config.TlsImplicit)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := conf.TlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	var c *client.Client
-	var err error
 	addr := fmt.Sprintf("%v:%v", conf.Host, conf.Port)
-	if conf.Tls.Enabled {
-		c, err = client.DialTLS(addr, conf.TlsConfig())
+	if mode == config.TlsImplicit {
+		c, err = client.DialTLS(addr, tlsConf)
 	} else {
 		c, err = client.Dial(addr)
 	}
 	if err != nil {
 		return nil, err
 	}
+	encrypted := mode == config.TlsImplicit
+	if mode == config.TlsStartTls || mode == config.TlsStartTlsRequired {
+		supported, err := c.SupportStartTLS()
+		if err != nil {
+			c.Logout()
+			return nil, err
+		}
+		if supported {
+			if err := c.StartTLS(tlsConf); err != nil {
+				c.Logout()
+				return nil, err
+			}
+			encrypted = true
+		} else if mode == config.TlsStartTlsRequired {
+			c.Logout()
+			return nil, fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+	}
+	if conf.Authenticated && !encrypted {
+		warnPlaintext(addr)
+	}
 	if conf.Authenticated {
-		if err := c.Login(conf.Address, conf.Password); err != nil {
+		if conf.AuthMechanism == AuthPlain {
+			err = c.Login(conf.Address, conf.Password)
+		} else {
+			var client sasl.Client
+			if client, err = saslClient(conf); err == nil {
+				err = c.Authenticate(client)
+			}
+		}
+		if err != nil {
+			c.Logout()
 			return nil, err
 		}
 	}
@@ -33,16 +73,41 @@ func ConnectImap(conf *config.ServerConfig) (*client.Client, error) {
 type Mail struct {
 	MessageId string
 	From      string
+	To        []string
+	Cc        []string
 	Subject   string
 	Location  *time.Location
+	Auth      map[string]string
+	Events    []*ical.Event
+}
+
+// Errors are only logged, since the message can still set a reminder.
+func calendarEvents(messageId string, raw []byte, loc *time.Location) []*ical.Event {
+	events := make([]*ical.Event, 0)
+	parts, err := CalendarParts(raw)
+	if err != nil {
+		log.Warn().Err(err).Msgf("error reading calendar parts of %q", messageId)
+		return events
+	}
+	for _, part := range parts {
+		partEvents, err := ical.Parse(part, loc)
+		if err != nil {
+			log.Warn().Err(err).Msgf("error parsing calendar of %q", messageId)
+			continue
+		}
+		events = append(events, partEvents...)
+	}
+	return events
 }
 
 type MailFetcher struct {
-	Conf        *config.Config
-	MaxMessages uint32
-	Done        <-chan bool
-	Mail        chan<- *Mail
-	Errors      chan<- error
+	Conf          *config.Config
+	MaxMessages   uint32
+	Done          <-chan bool
+	Mail          chan<- *Mail
+	Errors        chan<- error
+	Authenticator *Authenticator
+	Peek          bool
 }
 
 func NewMailFetcher(
@@ -50,7 +115,7 @@ func NewMailFetcher(
 ) (*MailFetcher, <-chan *Mail, <-chan error) {
 	mail := make(chan *Mail, maxMessages)
 	errors := make(chan error, 1)
-	return &MailFetcher{conf, maxMessages, done, mail, errors}, mail, errors
+	return &MailFetcher{conf, maxMessages, done, mail, errors, NewAuthenticator(conf.Auth), false}, mail, errors
 }
 
 func (f *MailFetcher) RunOnce() {
@@ -60,7 +125,7 @@ func (f *MailFetcher) RunOnce() {
 		return
 	}
 	defer imapClient.Logout()
-	mbox, err := imapClient.Select(f.Conf.Mailbox.In, false)
+	mbox, err := imapClient.Select(f.Conf.Mailbox.In, f.Peek)
 	if err != nil {
 		f.Errors <- err
 		return
@@ -72,30 +137,50 @@ func (f *MailFetcher) RunOnce() {
 	from, to := RangeLastN(f.MaxMessages, mbox.Messages)
 	log.Info().Msgf("%s/%s fetching messages %d-%d", f.Conf.IMAP.Address, f.Conf.Mailbox.In, from, to)
 	seqset := RangeSeq(from, to)
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchEnvelope, section.FetchItem()}
 	messages := make(chan *imap.Message, f.MaxMessages)
 	done := make(chan error, 1)
 	go func() {
-		if err := imapClient.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope}, messages); err != nil {
+		if err := imapClient.Fetch(seqset, items, messages); err != nil {
 			done <- err
+		} else if f.Peek {
+			done <- nil
 		} else {
 			done <- imapClient.Move(seqset, f.Conf.Mailbox.Processed)
 		}
 	}()
+	// Later stages expect the messages in the processed mailbox.
+	fetched := make([]*imap.Message, 0, f.MaxMessages)
 	for message := range messages {
+		fetched = append(fetched, message)
+	}
+	if err := <-done; err != nil {
+		f.Errors <- err
+	}
+	for _, message := range fetched {
 		if len(message.Envelope.From) == 0 {
 			f.Errors <- fmt.Errorf("message %q has no From", message.Envelope.MessageId)
 			return
 		}
+		from := message.Envelope.From[0].Address()
+		var raw []byte
+		if body := message.GetBody(section); body != nil {
+			if raw, err = io.ReadAll(body); err != nil {
+				f.Errors <- err
+			}
+		}
 		f.Mail <- &Mail{
-			From:      message.Envelope.From[0].Address(),
+			From:      from,
+			To:        addresses(message.Envelope.To),
+			Cc:        addresses(message.Envelope.Cc),
 			Subject:   message.Envelope.Subject,
 			MessageId: message.Envelope.MessageId,
 			Location:  f.Conf.Location(),
+			Auth:      f.Authenticator.Authenticate(from, raw),
+			Events:    calendarEvents(message.Envelope.MessageId, raw, f.Conf.Location()),
 		}
 	}
-	if err := <-done; err != nil {
-		f.Errors <- err
-	}
 }
 
 func (f *MailFetcher) Close() {
@@ -115,6 +200,69 @@ func (f *MailFetcher) Run() {
 	}
 }
 
+type MailRejecter struct {
+	Conf     *config.Config
+	Mail     <-chan *Mail
+	Errors   chan<- error
+	finished bool
+}
+
+func NewMailRejecter(conf *config.Config, mail <-chan *Mail) (*MailRejecter, <-chan error) {
+	errors := make(chan error, 1)
+	return &MailRejecter{conf, mail, errors, false}, errors
+}
+
+func (r *MailRejecter) RunOnce() {
+	m, ok := <-r.Mail
+	if !ok {
+		r.finished = true
+		return
+	}
+	if r.Conf.Mailbox.Rejected == "" {
+		return
+	}
+	if err := r.move(m); err != nil {
+		r.Errors <- fmt.Errorf("error moving rejected message %q to %s: %w", m.MessageId, r.Conf.Mailbox.Rejected, err)
+	}
+}
+
+func (r *MailRejecter) move(m *Mail) error {
+	if m.MessageId == "" {
+		return fmt.Errorf("message has no Message-Id")
+	}
+	imapClient, err := ConnectImap(r.Conf.IMAP)
+	if err != nil {
+		return err
+	}
+	defer imapClient.Logout()
+	if _, err := imapClient.Select(r.Conf.Mailbox.Processed, false); err != nil {
+		return err
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-Id", m.MessageId)
+	uids, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("message not found in %s", r.Conf.Mailbox.Processed)
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	return imapClient.UidMove(seqset, r.Conf.Mailbox.Rejected)
+}
+
+func (r *MailRejecter) Close() {
+	close(r.Errors)
+}
+
+func (r *MailRejecter) Run() {
+	defer r.Close()
+	for !r.finished {
+		r.RunOnce()
+	}
+}
+
 type MailFetchError struct {
 	Conf config.Config
 	Err  error
@@ -128,6 +276,14 @@ func (mfe *MailFetchError) Unwrap() error {
 	return mfe.Err
 }
 
+func addresses(list []*imap.Address) []string {
+	addrs := make([]string, 0, len(list))
+	for _, addr := range list {
+		addrs = append(addrs, addr.Address())
+	}
+	return addrs
+}
+
 func RangeLastN(n uint32, total uint32) (uint32, uint32) {
 	from := uint32(1)
 	to := total
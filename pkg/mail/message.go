@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+)
+
+type Attachment struct {
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []*Attachment
+}
+
+const base64LineLength = 76
+
+func writeBase64(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded + "\r\n")
+}
+
+func MakeMultipartMessage(from string, m *Message) (string, error) {
+	var parts bytes.Buffer
+	w := multipart.NewWriter(&parts)
+	body, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := body.Write([]byte(m.Body + "\r\n")); err != nil {
+		return "", err
+	}
+	for _, a := range m.Attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return "", err
+		}
+		var encoded bytes.Buffer
+		writeBase64(&encoded, a.Data)
+		if _, err := part.Write(encoded.Bytes()); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"From: %v\r\n"+
+			"To: %v\r\n"+
+			"Subject: %v\r\n"+
+			"MIME-Version: 1.0\r\n"+
+			"Content-Type: multipart/mixed;\r\n boundary=%q\r\n"+
+			"\r\n"+
+			"%s",
+		from,
+		m.To,
+		encodeSubject(m.Subject),
+		w.Boundary(),
+		parts.String(),
+	), nil
+}
@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMakeMultipartMessage(t *testing.T) {
+	data := []byte(strings.Repeat(testCalendar, 4))
+	raw, err := MakeMultipartMessage("reminders@example.com", &Message{
+		To:      "alice@example.com",
+		Subject: "Reminder set: do the thing",
+		Body:    "Your reminder is set.",
+		Attachments: []*Attachment{
+			{ContentType: "text/calendar; charset=utf-8; method=REQUEST", Filename: "reminder.ics", Data: data},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := netmail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("To") != "alice@example.com" || msg.Header.Get("Subject") != "Reminder set: do the thing" {
+		t.Errorf("got header %v", msg.Header)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(part)
+	if string(body) != "Your reminder is set.\r\n" {
+		t.Errorf("got body %q", body)
+	}
+
+	parts, err := CalendarParts([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || !bytes.Equal(parts[0], data) {
+		t.Errorf("got parts %q", parts)
+	}
+	for _, line := range strings.Split(raw, "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("line too long: %q", line)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+)
+
+const maxMimeDepth = 8
+
+func isCalendarType(mediaType string) bool {
+	return mediaType == "text/calendar" || mediaType == "application/ics"
+}
+
+// CalendarParts includes the parts of attached (forwarded) messages.
+func CalendarParts(raw []byte) ([][]byte, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]byte, 0)
+	err = walkCalendarParts(textproto.MIMEHeader(msg.Header), msg.Body, 0, &parts)
+	return parts, err
+}
+
+func walkCalendarParts(header textproto.MIMEHeader, body io.Reader, depth int, parts *[][]byte) error {
+	if depth > maxMimeDepth {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	// multipart.Reader already decodes quoted-printable parts.
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	switch {
+	case isCalendarType(mediaType):
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		*parts = append(*parts, data)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkCalendarParts(part.Header, part, depth+1, parts); err != nil {
+				return err
+			}
+		}
+	case mediaType == "message/rfc822":
+		msg, err := netmail.ReadMessage(body)
+		if err != nil {
+			return err
+		}
+		return walkCalendarParts(textproto.MIMEHeader(msg.Header), msg.Body, depth+1, parts)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Sync\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+func TestCalendarParts(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testCalendar))
+	// An invite forwarded as an attachment, with its calendar base64 encoded
+	// over two lines, and an .ics file encoded as quoted-printable.
+	raw := "From: alice@example.com\r\n" +
+		"To: reminders@example.com\r\n" +
+		"Subject: Fwd: Invitation: Sync\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See below.\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"From: bob@example.com\r\n" +
+		"Subject: Invitation: Sync\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"You are invited.\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/calendar; method=REQUEST; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		encoded[:40] + "\r\n" + encoded[40:] + "\r\n" +
+		"--inner--\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/ics; name=invite.ics\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"BEGIN:VCALENDAR\r\nSUMMARY:caf=C3=A9\r\nEND:VCALENDAR\r\n" +
+		"\r\n" +
+		"--outer--\r\n"
+	parts, err := CalendarParts([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts", len(parts))
+	}
+	if string(parts[0]) != testCalendar {
+		t.Errorf("got %q", parts[0])
+	}
+	if string(parts[1]) != "BEGIN:VCALENDAR\r\nSUMMARY:café\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("got %q", parts[1])
+	}
+
+	parts, err = CalendarParts([]byte(testMessage))
+	if err != nil || len(parts) != 0 {
+		t.Errorf("got %d parts (%v) in a plain message", len(parts), err)
+	}
+}
@@ -0,0 +1,242 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/jbchouinard/mxremind/pkg/config"
+)
+
+const (
+	AuthPlain       = "plain"
+	AuthXOAuth2     = "xoauth2"
+	AuthOAuthBearer = "oauthbearer"
+)
+
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Valid reports whether the token can be used for at least another minute.
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Until(t.Expiry) > time.Minute)
+}
+
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+type StaticTokenSource struct {
+	AccessToken string
+}
+
+func (s *StaticTokenSource) Token() (*Token, error) {
+	return &Token{AccessToken: s.AccessToken}, nil
+}
+
+// A rotated refresh token is used for the next request.
+type RefreshTokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	Scopes       []string
+	Client       *http.Client
+}
+
+func (s *RefreshTokenSource) Token() (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.RefreshToken},
+		"client_id":     {s.ClientID},
+	}
+	if s.ClientSecret != "" {
+		form.Set("client_secret", s.ClientSecret)
+	}
+	if len(s.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.PostForm(s.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return nil, fmt.Errorf("error refreshing token (%s): %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.RefreshToken != "" {
+		s.RefreshToken = body.RefreshToken
+	}
+	token := &Token{AccessToken: body.AccessToken, RefreshToken: s.RefreshToken}
+	if body.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// If File is set, the token is stored there to survive restarts.
+type CachedTokenSource struct {
+	Source TokenSource
+	File   string
+	mu     sync.Mutex
+	token  *Token
+}
+
+func NewCachedTokenSource(source TokenSource, file string) *CachedTokenSource {
+	cached := &CachedTokenSource{Source: source, File: file}
+	if file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			var token Token
+			if err := json.Unmarshal(data, &token); err == nil {
+				cached.token = &token
+				if refresher, ok := source.(*RefreshTokenSource); ok && token.RefreshToken != "" {
+					refresher.RefreshToken = token.RefreshToken
+				}
+			}
+		}
+	}
+	return cached
+}
+
+func (s *CachedTokenSource) Token() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	token, err := s.Source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	if s.File != "" {
+		data, err := json.Marshal(token)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(s.File, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+var tokenSources = make(map[string]TokenSource)
+var tokenSourcesMu sync.Mutex
+
+func SetTokenSource(conf *config.OAuth2Config, source TokenSource) {
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+	tokenSources[tokenSourceKey(conf)] = source
+}
+
+// The same source is shared by all connections with a configuration.
+func TokenSourceFor(conf *config.OAuth2Config) TokenSource {
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+	key := tokenSourceKey(conf)
+	if source, ok := tokenSources[key]; ok {
+		return source
+	}
+	var source TokenSource
+	if conf.RefreshToken != "" {
+		source = NewCachedTokenSource(&RefreshTokenSource{
+			TokenURL:     conf.TokenURL,
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RefreshToken: conf.RefreshToken,
+			Scopes:       conf.Scopes,
+		}, conf.CacheFile)
+	} else {
+		source = &StaticTokenSource{conf.AccessToken}
+	}
+	tokenSources[key] = source
+	return source
+}
+
+func tokenSourceKey(conf *config.OAuth2Config) string {
+	return strings.Join([]string{
+		conf.TokenURL, conf.ClientID, conf.RefreshToken, conf.AccessToken, conf.CacheFile,
+	}, "\x00")
+}
+
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01"), nil
+}
+
+// The empty response lets the server fail the exchange after an error challenge.
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
+
+func NewXOAuth2Client(username string, token string) sasl.Client {
+	return &xoauth2Client{username, token}
+}
+
+func saslClient(conf *config.ServerConfig) (sasl.Client, error) {
+	if conf.OAuth2 == nil {
+		return nil, errors.New("missing oauth2 configuration")
+	}
+	token, err := TokenSourceFor(conf.OAuth2).Token()
+	if err != nil {
+		return nil, err
+	}
+	switch conf.AuthMechanism {
+	case AuthXOAuth2:
+		return NewXOAuth2Client(conf.Address, token.AccessToken), nil
+	case AuthOAuthBearer:
+		return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: conf.Address,
+			Token:    token.AccessToken,
+			Host:     conf.Host,
+			Port:     int(conf.Port),
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown authentication mechanism %q", conf.AuthMechanism)
+	}
+}
+
+type smtpSaslAuth struct {
+	client sasl.Client
+}
+
+func (a *smtpSaslAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return a.client.Start()
+}
+
+func (a *smtpSaslAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
+}
@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCachedRefreshTokenSource(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("client_id") != "mxremind" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+		if r.Form.Get("refresh_token") != "refresh-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access-1",
+			"refresh_token": "refresh-2",
+			"expires_in":    3600,
+		})
+	}))
+	defer server.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "token.json")
+	refresher := &RefreshTokenSource{TokenURL: server.URL, ClientID: "mxremind", RefreshToken: "refresh-1"}
+	source := NewCachedTokenSource(refresher, cacheFile)
+	for i := 0; i < 2; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "access-1" {
+			t.Errorf("got access token %q", token.AccessToken)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 token request, got %d", requests)
+	}
+	if refresher.RefreshToken != "refresh-2" {
+		t.Errorf("refresh token was not rotated: %q", refresher.RefreshToken)
+	}
+
+	// A new source reads the cached token and the rotated refresh token.
+	refresher = &RefreshTokenSource{TokenURL: server.URL, ClientID: "mxremind", RefreshToken: "refresh-1"}
+	token, err := NewCachedTokenSource(refresher, cacheFile).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || requests != 1 || refresher.RefreshToken != "refresh-2" {
+		t.Errorf("cache not used: %+v, %d requests", token, requests)
+	}
+
+	// The rotated refresh token is rejected by the stub endpoint.
+	if _, err := refresher.Token(); err == nil {
+		t.Error("expected error for invalid grant")
+	}
+}
+
+func TestXOAuth2Client(t *testing.T) {
+	mech, ir, err := NewXOAuth2Client("alice@example.com", "access-1").Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mech != "XOAUTH2" || string(ir) != "user=alice@example.com\x01auth=Bearer access-1\x01\x01" {
+		t.Errorf("got %s %q", mech, ir)
+	}
+}
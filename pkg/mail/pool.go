@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+type pooledSmtpClient struct {
+	client   *SmtpClient
+	lastUsed time.Time
+}
+
+// SmtpPool drops connections after any error.
+type SmtpPool struct {
+	Conf        *config.ServerConfig
+	IdleTimeout time.Duration
+	slots       chan struct{}
+	mu          sync.Mutex
+	idle        []*pooledSmtpClient
+	done        chan bool
+	closeOnce   sync.Once
+}
+
+func NewSmtpPool(conf *config.ServerConfig) *SmtpPool {
+	idleTimeout := conf.Pool.IdleTimeoutDuration()
+	if idleTimeout <= 0 {
+		idleTimeout = time.Minute
+	}
+	pool := &SmtpPool{
+		Conf:        conf,
+		IdleTimeout: idleTimeout,
+		slots:       make(chan struct{}, conf.Pool.Size),
+		done:        make(chan bool),
+	}
+	go pool.reap()
+	return pool
+}
+
+func (p *SmtpPool) Send(to string, subject string, body string) error {
+	return p.SendMessage(&Message{To: to, Subject: subject, Body: body})
+}
+
+func (p *SmtpPool) SendMessage(m *Message) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	conn, err := p.get()
+	if err != nil {
+		return err
+	}
+	if err := conn.client.SendMessage(m); err != nil {
+		conn.client.Close()
+		return err
+	}
+	p.put(conn)
+	return nil
+}
+
+// Idle connections are checked with RSET before they are reused.
+func (p *SmtpPool) get() (*pooledSmtpClient, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		if time.Since(conn.lastUsed) < p.IdleTimeout && conn.client.Reset() == nil {
+			return conn, nil
+		}
+		conn.client.Close()
+	}
+	client, err := ConnectSmtp(p.Conf)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("opened SMTP connection to %s", p.Conf.Host)
+	return &pooledSmtpClient{client: client}, nil
+}
+
+func (p *SmtpPool) put(conn *pooledSmtpClient) {
+	conn.lastUsed = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, conn)
+}
+
+func (p *SmtpPool) reap() {
+	ticker := time.NewTicker(p.IdleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		active := make([]*pooledSmtpClient, 0, len(p.idle))
+		expired := make([]*pooledSmtpClient, 0)
+		for _, conn := range p.idle {
+			if time.Since(conn.lastUsed) >= p.IdleTimeout {
+				expired = append(expired, conn)
+			} else {
+				active = append(active, conn)
+			}
+		}
+		p.idle = active
+		p.mu.Unlock()
+		for _, conn := range expired {
+			conn.client.Quit()
+		}
+	}
+}
+
+// Close must be called after the last Send.
+func (p *SmtpPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, conn := range p.idle {
+			conn.client.Quit()
+		}
+		p.idle = nil
+	})
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+)
+
+// stubSmtpServer accepts any mail, and counts connections and messages.
+type stubSmtpServer struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	connections int
+	messages    int
+	resets      int
+}
+
+func newStubSmtpServer(t *testing.T) *stubSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &stubSmtpServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.connections++
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *stubSmtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 stub ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			fmt.Fprint(conn, "250 stub\r\n")
+		case "DATA":
+			fmt.Fprint(conn, "354 go ahead\r\n")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.messages++
+			s.mu.Unlock()
+			fmt.Fprint(conn, "250 queued\r\n")
+		case "RSET":
+			s.mu.Lock()
+			s.resets++
+			s.mu.Unlock()
+			fmt.Fprint(conn, "250 ok\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 ok\r\n")
+		}
+	}
+}
+
+func TestSmtpPoolReusesConnections(t *testing.T) {
+	server := newStubSmtpServer(t)
+	defer server.listener.Close()
+	addr := server.listener.Addr().(*net.TCPAddr)
+	conf := &config.ServerConfig{
+		Address:       "reminders@example.com",
+		Authenticated: false,
+		Host:          "127.0.0.1",
+		Port:          uint16(addr.Port),
+		Tls:           &config.TlsConfig{Mode: config.TlsNone},
+		Pool:          &config.PoolConfig{Size: 2, IdleTimeout: 60},
+	}
+	pool := NewSmtpPool(conf)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := pool.Send(fmt.Sprintf("user%d@example.com", i), "Reminder: test", ""); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.messages != 20 {
+		t.Errorf("expected 20 messages, got %d", server.messages)
+	}
+	if server.connections > 2 {
+		t.Errorf("expected at most 2 connections, got %d", server.connections)
+	}
+	if server.resets != 20-server.connections {
+		t.Errorf("expected a RSET before each reuse, got %d", server.resets)
+	}
+}
@@ -1,10 +1,14 @@
 package mail
 
 import (
+	"crypto/tls"
 	"fmt"
+	"mime"
 	"net/smtp"
+	"strings"
 
 	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/rs/zerolog/log"
 )
 
 type SmtpClient struct {
@@ -13,20 +17,63 @@ type SmtpClient struct {
 }
 
 func ConnectSmtp(conf *config.ServerConfig) (*SmtpClient, error) {
-	client, err := smtp.Dial(fmt.Sprintf("%v:%v", conf.Host, conf.Port))
+	mode, err := conf.Tls.GetMode(config.TlsStartTlsRequired)
 	if err != nil {
 		return nil, err
 	}
-
-	if conf.Tls.Enabled {
-		err = client.StartTLS(conf.TlsConfig())
+	tlsConf, err := conf.TlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	addr := fmt.Sprintf("%v:%v", conf.Host, conf.Port)
+	var client *smtp.Client
+	if mode == config.TlsImplicit {
+		conn, err := tls.Dial("tcp", addr, tlsConf)
+		if err != nil {
+			return nil, err
+		}
+		client, err = smtp.NewClient(conn, conf.Host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		client, err = smtp.Dial(addr)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	encrypted := mode == config.TlsImplicit
+	if mode == config.TlsStartTls || mode == config.TlsStartTlsRequired {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			err = client.StartTLS(tlsConf)
+			if err != nil {
+				client.Close()
+				return nil, err
+			}
+			encrypted = true
+		} else if mode == config.TlsStartTlsRequired {
+			client.Close()
+			return nil, fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+	}
+	if conf.Authenticated && !encrypted {
+		warnPlaintext(addr)
+	}
+
 	if conf.Authenticated {
-		err = client.Auth(smtp.PlainAuth("", conf.Address, conf.Password, conf.Host))
+		var auth smtp.Auth
+		if conf.AuthMechanism == AuthPlain {
+			auth = smtp.PlainAuth("", conf.Address, conf.Password, conf.Host)
+		} else {
+			oauthClient, err := saslClient(conf)
+			if err != nil {
+				return nil, err
+			}
+			auth = &smtpSaslAuth{oauthClient}
+		}
+		err = client.Auth(auth)
 		if err != nil {
 			return nil, err
 		}
@@ -35,6 +82,17 @@ func ConnectSmtp(conf *config.ServerConfig) (*SmtpClient, error) {
 	return &SmtpClient{conf.Address, client}, nil
 }
 
+func warnPlaintext(addr string) {
+	log.Warn().Msgf("sending credentials to %s over an unencrypted connection", addr)
+}
+
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// encodeSubject keeps line breaks in reminder content from starting new headers.
+func encodeSubject(subject string) string {
+	return mime.QEncoding.Encode("utf-8", lineBreaks.Replace(subject))
+}
+
 func MakeMessage(from *string, to *string, subject *string, body *string) string {
 	return fmt.Sprintf(
 		"From: %v\r\n"+
@@ -44,18 +102,28 @@ func MakeMessage(from *string, to *string, subject *string, body *string) string
 			"%v\r\n",
 		*from,
 		*to,
-		*subject,
+		encodeSubject(*subject),
 		*body,
 	)
 }
 
 func (client *SmtpClient) Send(to string, subject string, body string) error {
-	message := MakeMessage(&client.username, &to, &subject, &body)
+	return client.SendMessage(&Message{To: to, Subject: subject, Body: body})
+}
+
+func (client *SmtpClient) SendMessage(m *Message) error {
+	message := MakeMessage(&client.username, &m.To, &m.Subject, &m.Body)
+	if len(m.Attachments) > 0 {
+		var err error
+		if message, err = MakeMultipartMessage(client.username, m); err != nil {
+			return err
+		}
+	}
 	err := client.smtpClient.Mail(client.username)
 	if err != nil {
 		return err
 	}
-	err = client.smtpClient.Rcpt(to)
+	err = client.smtpClient.Rcpt(m.To)
 	if err != nil {
 		return err
 	}
@@ -78,16 +146,29 @@ func (client *SmtpClient) Quit() error {
 	return client.smtpClient.Quit()
 }
 
+func (client *SmtpClient) Reset() error {
+	return client.smtpClient.Reset()
+}
+
+// Close does not send QUIT.
+func (client *SmtpClient) Close() error {
+	return client.smtpClient.Close()
+}
+
 // SmtpSender uses a new connection for every e-mail.
 type SmtpSender struct {
 	Conf *config.ServerConfig
 }
 
 func (s *SmtpSender) Send(to string, subject string, body string) error {
+	return s.SendMessage(&Message{To: to, Subject: subject, Body: body})
+}
+
+func (s *SmtpSender) SendMessage(m *Message) error {
 	client, err := ConnectSmtp(s.Conf)
 	if err != nil {
 		return err
 	}
 	defer client.Quit()
-	return client.Send(to, subject, body)
+	return client.SendMessage(m)
 }
@@ -0,0 +1,25 @@
+package mail
+
+import (
+	"mime"
+	netmail "net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMakeMessageSubject(t *testing.T) {
+	from, to, body := "reminders@example.com", "alice@example.com", "body"
+	for _, subject := range []string{"Reminder: x\r\nBcc: mallory@evil.com", "Reminder: x\nBcc: mallory@evil.com", "Rappel : café"} {
+		msg, err := netmail.ReadMessage(strings.NewReader(MakeMessage(&from, &to, &subject, &body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Header["Bcc"]) > 0 {
+			t.Errorf("%q: injected Bcc header", subject)
+		}
+		decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil || strings.ContainsAny(decoded, "\r\n") || !strings.HasPrefix(decoded, subject[:9]) {
+			t.Errorf("%q: got subject %q %v", subject, decoded, err)
+		}
+	}
+}
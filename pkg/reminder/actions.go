@@ -0,0 +1,214 @@
+package reminder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ActionDone     = "done"
+	ActionSnooze   = "snooze"
+	ActionTomorrow = "tomorrow"
+	ActionCancel   = "cancel"
+)
+
+// Actions are listed in e-mails in this order.
+var Actions = []string{ActionDone, ActionSnooze, ActionTomorrow, ActionCancel}
+
+var actionLabels = map[string]string{
+	ActionDone:     "Done",
+	ActionSnooze:   "Snooze for an hour",
+	ActionTomorrow: "Snooze until tomorrow",
+	ActionCancel:   "Cancel",
+}
+
+func ActionLabel(action string) string {
+	return actionLabels[action]
+}
+
+const ActionLinkLifetime = 7 * 24 * time.Hour
+
+const SnoozeDuration = time.Hour
+
+// TomorrowTime is in the timezone of the user.
+const TomorrowTime = 9 * time.Hour
+
+var ErrInvalidActionToken = errors.New("invalid action token")
+
+var ErrExpiredActionToken = errors.New("expired action token")
+
+type ActionToken struct {
+	Action     string
+	ReminderId uuid.UUID
+	Recipient  string
+	Expires    time.Time
+}
+
+// ActionLinks let recipients act on reminders without logging in.
+type ActionLinks struct {
+	BaseURL string
+	Key     []byte
+}
+
+// NewActionLinks returns nil if no signing key is configured.
+func NewActionLinks(conf *config.HTTPConfig) *ActionLinks {
+	if conf.SigningKey == "" {
+		return nil
+	}
+	return &ActionLinks{BaseURL: conf.BaseURL, Key: []byte(conf.SigningKey)}
+}
+
+func (l *ActionLinks) mac(payload string) []byte {
+	h := hmac.New(sha256.New, l.Key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func (l *ActionLinks) Sign(t *ActionToken) string {
+	payload := strings.Join([]string{
+		t.Action,
+		t.ReminderId.String(),
+		strings.ToLower(t.Recipient),
+		strconv.FormatInt(t.Expires.Unix(), 10),
+	}, "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(l.mac(payload))
+}
+
+func (l *ActionLinks) Verify(token string, now time.Time) (*ActionToken, error) {
+	encPayload, encMac, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidActionToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return nil, ErrInvalidActionToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encMac)
+	if err != nil || !hmac.Equal(mac, l.mac(string(payload))) {
+		return nil, ErrInvalidActionToken
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 4 {
+		return nil, ErrInvalidActionToken
+	}
+	id, err := uuid.FromString(fields[1])
+	if err != nil {
+		return nil, ErrInvalidActionToken
+	}
+	expires, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidActionToken
+	}
+	t := &ActionToken{
+		Action:     fields[0],
+		ReminderId: id,
+		Recipient:  fields[2],
+		Expires:    time.Unix(expires, 0),
+	}
+	if actionLabels[t.Action] == "" {
+		return nil, ErrInvalidActionToken
+	}
+	if !now.Before(t.Expires) {
+		return nil, ErrExpiredActionToken
+	}
+	return t, nil
+}
+
+func (l *ActionLinks) URL(action string, rem *Reminder, to string, now time.Time) string {
+	return l.BaseURL + "/actions/" + l.Sign(&ActionToken{
+		Action:     action,
+		ReminderId: rem.Id,
+		Recipient:  to,
+		Expires:    now.Add(ActionLinkLifetime),
+	})
+}
+
+func (l *ActionLinks) Body(rem *Reminder, to string, now time.Time) string {
+	lines := make([]string, 0, len(Actions))
+	for _, action := range Actions {
+		lines = append(lines, fmt.Sprintf("%s: %s", ActionLabel(action), l.URL(action, rem, to, now)))
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func (l *ActionLinks) AppendTo(body string, rem *Reminder, to string, now time.Time) string {
+	if l == nil {
+		return body
+	}
+	if body != "" {
+		body += "\r\n\r\n"
+	}
+	return body + l.Body(rem, to, now)
+}
+
+func ActionUntil(action string, now time.Time, loc *time.Location) time.Time {
+	if action == ActionTomorrow {
+		y, m, d := now.In(loc).Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, loc).Add(TomorrowTime)
+	}
+	return now.Add(SnoozeDuration)
+}
+
+// Location is used for recipients who have no timezone set.
+type LinkAction struct {
+	Token    *ActionToken
+	Now      time.Time
+	Location *time.Location
+}
+
+func (rem *Reminder) recipient(address string) string {
+	for _, to := range append(rem.AllRecipients(), rem.EscalateTo) {
+		if to != "" && strings.EqualFold(to, address) {
+			return to
+		}
+	}
+	return ""
+}
+
+func (c *LinkAction) Apply(dao *ReminderDAO) error {
+	t := c.Token
+	rem, err := dao.Load(t.ReminderId)
+	if err != nil {
+		return err
+	}
+	to := rem.recipient(t.Recipient)
+	if to == "" {
+		return fmt.Errorf("%q is not a recipient of reminder %q", t.Recipient, rem.Id)
+	}
+	if rem.IsCancelled {
+		return fmt.Errorf("reminder %q is cancelled", rem.Id)
+	}
+	switch t.Action {
+	case ActionDone:
+		if !rem.IsSent {
+			err = dao.Cancel(rem.Id)
+		} else if !rem.IsAcknowledged {
+			err = dao.Acknowledge(rem.Id, to)
+		}
+	case ActionSnooze, ActionTomorrow:
+		var prefs *Preferences
+		prefs, err = (&UserDAO{Tx: dao.Tx, Context: dao.Context}).Preferences(to)
+		if err == nil {
+			loc := loadLocation(to, prefs.Timezone, c.Location)
+			err = dao.Snooze(rem.Id, ActionUntil(t.Action, c.Now, loc))
+		}
+	case ActionCancel:
+		err = dao.Cancel(rem.Id)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("action %q applied to reminder %q by %q", t.Action, rem.Id, to)
+	return nil
+}
@@ -0,0 +1,271 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/rs/zerolog/log"
+)
+
+type MessageSender interface {
+	Sender
+	SendMessage(m *mail.Message) error
+}
+
+type Invites struct {
+	Organizer string
+}
+
+func NewInvites(conf *config.Config) *Invites {
+	if !conf.Calendar.Attach {
+		return nil
+	}
+	return &Invites{Organizer: conf.SMTP.Address}
+}
+
+// The UID only depends on the reminder, so that updates replace the same event.
+func (rem *Reminder) Event() *ical.Event {
+	return &ical.Event{
+		UID:     rem.Id.String() + "@mxremind",
+		Summary: rem.Content,
+		Start:   rem.DueTime,
+		End:     rem.DueTime,
+	}
+}
+
+func (i *Invites) Event(rem *Reminder, to string) *ical.Event {
+	event := rem.Event()
+	event.Alarms = []time.Time{rem.DueTime}
+	event.Organizer = i.Organizer
+	event.Attendees = []string{to}
+	return event
+}
+
+// The revision of the reminder is the SEQUENCE of the event.
+func (i *Invites) Attachment(method string, rem *Reminder, to string) *mail.Attachment {
+	event := i.Event(rem, to)
+	event.Sequence = rem.Revision
+	return &mail.Attachment{
+		ContentType: "text/calendar; charset=utf-8; method=" + method,
+		Filename:    "reminder.ics",
+		Data:        ical.Write(method, event, time.Now()),
+	}
+}
+
+// Send reports whether the sender could attach the event.
+func (i *Invites) Send(sender Sender, method string, rem *Reminder, to string, subject string, body string) (bool, error) {
+	messageSender, ok := sender.(MessageSender)
+	if i == nil || !ok {
+		return false, sender.Send(to, subject, body)
+	}
+	return true, messageSender.SendMessage(&mail.Message{
+		To:          to,
+		Subject:     subject,
+		Body:        body,
+		Attachments: []*mail.Attachment{i.Attachment(method, rem, to)},
+	})
+}
+
+type Confirmations struct {
+	Sender   Sender
+	Quota    *Quota
+	Links    *ActionLinks
+	Invites  *Invites
+	Location *time.Location
+}
+
+func NewConfirmations(conf *config.Config, sender Sender, quota *Quota) *Confirmations {
+	if !conf.Confirm {
+		return nil
+	}
+	return &Confirmations{sender, quota, NewActionLinks(conf.HTTP), NewInvites(conf), conf.Location()}
+}
+
+// The reminder is saved even if its confirmation cannot be sent.
+type Confirmation struct {
+	Reminder      *Reminder
+	Confirmations *Confirmations
+	body          string
+	deliveryId    int64
+}
+
+func (c *Confirmation) Apply(store ReminderStore) error {
+	dao, err := postgres(store)
+	if err != nil {
+		return err
+	}
+	rem := c.Reminder
+	if err := dao.Save(rem); err != nil {
+		return err
+	}
+	var quotaErr *QuotaError
+	if err := c.Confirmations.Quota.CheckSend(dao, rem.Recipient); errors.As(err, &quotaErr) {
+		log.Warn().Err(err).Msgf("reminder %q not confirmed", rem.Id)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	prefs, err := (&UserDAO{Tx: dao.Tx, Context: dao.Context}).Preferences(rem.Recipient)
+	if err != nil {
+		return err
+	}
+	loc := loadLocation(rem.Recipient, prefs.Timezone, c.Confirmations.Location)
+	c.body = c.Confirmations.Links.AppendTo(
+		fmt.Sprintf("Your reminder is set for %s.", rem.DueTime.In(loc).Format("Monday, January 2 2006 at 15:04 MST")),
+		rem,
+		rem.Recipient,
+		time.Now(),
+	)
+	c.deliveryId, err = dao.SaveDelivery(nil, rem.Recipient, ChannelEmail, time.Now())
+	return err
+}
+
+func (c *Confirmation) Reply(store Store) error {
+	if c.deliveryId == 0 {
+		return nil
+	}
+	rem := c.Reminder
+	invited, err := c.Confirmations.Invites.Send(
+		c.Confirmations.Sender, ical.MethodRequest, rem, rem.Recipient, "Reminder set: "+rem.Content, c.body,
+	)
+	if err != nil {
+		deleteDelivery(store, c.deliveryId)
+		log.Warn().Err(err).Msgf("reminder %q not confirmed", rem.Id)
+		return nil
+	}
+	if !invited {
+		return nil
+	}
+	return store.WithTx(context.Background(), func(store ReminderStore) error {
+		dao, err := postgres(store)
+		if err != nil {
+			return err
+		}
+		return dao.SetInvited(rem.Id)
+	})
+}
+
+type InviteCanceller struct {
+	Store    Store
+	Done     <-chan bool
+	Sender   Sender
+	Quota    *Quota
+	Invites  *Invites
+	Errors   chan<- error
+	Interval time.Duration
+}
+
+func NewInviteCanceller(
+	interval time.Duration, pool *pgxpool.Pool, sender Sender, quota *Quota, invites *Invites, done <-chan bool,
+) (*InviteCanceller, <-chan error) {
+	errors := make(chan error, 1)
+	return &InviteCanceller{&PostgresStore{pool}, done, sender, quota, invites, errors, interval}, errors
+}
+
+func (ic *InviteCanceller) RunOnce() {
+	if ic.Invites == nil {
+		return
+	}
+	var reminders []*Reminder
+	err := ic.withDAO(func(dao *ReminderDAO) error {
+		var err error
+		reminders, err = dao.QueryInviteCancellations()
+		return err
+	})
+	if err != nil {
+		ic.Errors <- err
+		return
+	}
+	for _, rem := range reminders {
+		if err := ic.cancel(rem); err != nil {
+			ic.Errors <- fmt.Errorf("error cancelling the event of reminder %q: %w", rem.Id, err)
+		}
+	}
+}
+
+// The event is marked cancelled even if some cancellations failed, so that the
+// others are not sent again.
+func (ic *InviteCanceller) cancel(rem *Reminder) error {
+	var recipients []string
+	err := ic.withDAO(func(dao *ReminderDAO) error {
+		var err error
+		recipients, err = dao.InviteRecipients(rem.Id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	for _, to := range recipients {
+		if err := ic.send(rem, to); err != nil && sendErr == nil {
+			sendErr = fmt.Errorf("to %q: %w", to, err)
+		}
+	}
+	err = ic.withDAO(func(dao *ReminderDAO) error {
+		return dao.SetInviteCancelled(rem.Id)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("cancelled the event of reminder %q", rem.Id)
+	return sendErr
+}
+
+func (ic *InviteCanceller) send(rem *Reminder, to string) error {
+	var deliveryId int64
+	err := ic.Store.WithTx(context.Background(), func(store ReminderStore) error {
+		if err := ic.Quota.CheckSend(store, to); err != nil {
+			return err
+		}
+		var err error
+		deliveryId, err = store.SaveDelivery(nil, to, ChannelEmail, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ic.Invites.Send(
+		ic.Sender,
+		ical.MethodCancel,
+		rem,
+		to,
+		"Reminder cancelled: "+rem.Content,
+		"This reminder was cancelled.",
+	)
+	if err != nil {
+		deleteDelivery(ic.Store, deliveryId)
+	}
+	return err
+}
+
+func (ic *InviteCanceller) withDAO(f func(dao *ReminderDAO) error) error {
+	return ic.Store.WithTx(context.Background(), func(store ReminderStore) error {
+		dao, err := postgres(store)
+		if err != nil {
+			return err
+		}
+		return f(dao)
+	})
+}
+
+func (ic *InviteCanceller) Close() {
+	close(ic.Errors)
+}
+
+func (ic *InviteCanceller) Run() {
+	defer ic.Close()
+	for {
+		select {
+		case <-ic.Done:
+			return
+		case <-time.After(ic.Interval):
+		}
+		ic.RunOnce()
+	}
+}
@@ -0,0 +1,113 @@
+package reminder
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+)
+
+func testPostgresStore(t *testing.T) Store {
+	url := os.Getenv("MXREMIND_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("MXREMIND_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	if err := db.Migrate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenStore(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+func TestInviteCancellations(t *testing.T) {
+	store := testPostgresStore(t)
+	recorder := &messageRecorder{}
+	quota := &Quota{Defaults: &Limits{}}
+	invites := &Invites{Organizer: "reminders@example.com"}
+	confirmations := &Confirmations{
+		Sender:   recorder,
+		Quota:    quota,
+		Links:    &ActionLinks{BaseURL: "https://reminders.example.com", Key: []byte("secret")},
+		Invites:  invites,
+		Location: time.UTC,
+	}
+	rem := &Reminder{
+		Id:         uuid.Must(uuid.NewV4()),
+		Recipient:  "alice@example.com",
+		Recipients: []string{"bob@example.com"},
+		Content:    "deploy",
+		DueTime:    time.Now().Add(time.Hour),
+	}
+	commands := make(chan Command, 1)
+	saver, errs := NewReminderSaver(store, commands, recorder, quota, confirmations)
+	commands <- &NewReminder{rem}
+	close(commands)
+	saver.Run()
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(recorder.messages) != 1 {
+		t.Fatalf("got %d confirmations", len(recorder.messages))
+	}
+	if m := recorder.messages[0]; len(m.Attachments) != 1 || !strings.Contains(m.Body, "Cancel: https://reminders.example.com/") {
+		t.Errorf("got confirmation %+v", m)
+	}
+
+	err := store.WithTx(context.Background(), func(store ReminderStore) error {
+		if _, err := store.SaveDelivery(&rem.Id, "bob@example.com", ChannelEmail, time.Now()); err != nil {
+			return err
+		}
+		dao, err := postgres(store)
+		if err != nil {
+			return err
+		}
+		return dao.Cancel(rem.Id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancellations := func() map[string]*ical.Event {
+		recorder.messages = nil
+		errs := make(chan error, 10)
+		canceller := &InviteCanceller{Store: store, Sender: recorder, Quota: quota, Invites: invites, Errors: errs}
+		canceller.RunOnce()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		events := make(map[string]*ical.Event)
+		for _, m := range recorder.messages {
+			parsed, err := ical.Parse(m.Attachments[0].Data, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed[0].UID == rem.Id.String()+"@mxremind" {
+				events[m.To] = parsed[0]
+			}
+		}
+		return events
+	}
+	events := cancellations()
+	if len(events) != 2 {
+		t.Fatalf("got cancellations for %v, expected alice and bob", events)
+	}
+	for to, event := range events {
+		if !event.IsCancelled() || event.Sequence != 1 {
+			t.Errorf("%s: got %+v", to, event)
+		}
+	}
+	if events := cancellations(); len(events) != 0 {
+		t.Errorf("got cancellations for %v again", events)
+	}
+}
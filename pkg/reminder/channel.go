@@ -0,0 +1,59 @@
+package reminder
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/webhook"
+)
+
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// ChannelDigest leaves reminders for the next digest of their owner.
+const ChannelDigest = "digest"
+
+type Channel interface {
+	Deliver(rem *Reminder, to string) error
+}
+
+type MailChannel struct {
+	Sender  Sender
+	Links   *ActionLinks
+	Invites *Invites
+}
+
+func (c *MailChannel) Deliver(rem *Reminder, to string) error {
+	body := c.Links.AppendTo(rem.Body(), rem, to, time.Now())
+	_, err := c.Invites.Send(c.Sender, ical.MethodRequest, rem, to, rem.Subject(), body)
+	return err
+}
+
+type WebhookPayload struct {
+	Id        uuid.UUID `json:"id"`
+	Recipient string    `json:"recipient"`
+	Content   string    `json:"content"`
+	DueTime   time.Time `json:"due_time"`
+	Notes     string    `json:"notes"`
+	Tags      []string  `json:"tags"`
+	Priority  string    `json:"priority"`
+}
+
+type WebhookChannel struct {
+	Client *webhook.Client
+}
+
+func (c *WebhookChannel) Deliver(rem *Reminder, to string) error {
+	return c.Client.Post(&WebhookPayload{
+		Id:        rem.Id,
+		Recipient: to,
+		Content:   rem.Content,
+		DueTime:   rem.DueTime.UTC(),
+		Notes:     rem.Body(),
+		Tags:      rem.tags(),
+		Priority:  rem.priority(),
+	})
+}
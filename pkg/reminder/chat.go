@@ -0,0 +1,121 @@
+package reminder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/webhook"
+)
+
+// Chat rooms may be shared, so the message names the recipient.
+func chatText(rem *Reminder, to string) string {
+	text := fmt.Sprintf("%s (for %s)", rem.Subject(), to)
+	if body := strings.ReplaceAll(rem.Body(), "\r\n", "\n"); body != "" {
+		text += "\n\n" + body
+	}
+	return text
+}
+
+type SlackChannel struct {
+	Client *webhook.Client
+}
+
+func (c *SlackChannel) Deliver(rem *Reminder, to string) error {
+	return c.Client.Post(map[string]string{"text": chatText(rem, to)})
+}
+
+type NtfyChannel struct {
+	Client *webhook.Client
+	Token  string
+}
+
+func (c *NtfyChannel) Deliver(rem *Reminder, to string) error {
+	header := http.Header{
+		"Content-Type": {"text/plain; charset=utf-8"},
+		"Title":        {rem.Subject()},
+	}
+	if rem.IsEscalated {
+		header.Set("Priority", "high")
+	}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.Client.Send(&webhook.Request{
+		Method: http.MethodPost,
+		URL:    c.Client.URL,
+		Header: header,
+		Body:   []byte(chatText(rem, to)),
+	})
+}
+
+type MatrixChannel struct {
+	Client *webhook.Client
+	RoomID string
+	Token  string
+}
+
+func (c *MatrixChannel) Deliver(rem *Reminder, to string) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": chatText(rem, to)})
+	if err != nil {
+		return err
+	}
+	// The transaction ID is stable across retries, so that they do not post
+	// twice, but changes when the reminder is rescheduled or requeued.
+	txnID := fmt.Sprintf("%s-%d-%d-%s", rem.Id, rem.Revision, rem.DueTime.Unix(), to)
+	if rem.IsEscalated {
+		txnID += "-escalated"
+	}
+	return c.Client.Send(&webhook.Request{
+		Method: http.MethodPut,
+		URL: fmt.Sprintf(
+			"%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+			strings.TrimRight(c.Client.URL, "/"), url.PathEscape(c.RoomID), url.PathEscape(txnID),
+		),
+		Header: http.Header{
+			"Content-Type":  {"application/json"},
+			"Authorization": {"Bearer " + c.Token},
+		},
+		Body: body,
+	})
+}
+
+func NewChannel(conf *config.ChannelConfig) (Channel, error) {
+	client := webhook.NewClient(conf.Webhook())
+	switch conf.Type {
+	case config.ChannelTypeWebhook:
+		return &WebhookChannel{client}, nil
+	case config.ChannelTypeSlack:
+		return &SlackChannel{client}, nil
+	case config.ChannelTypeNtfy:
+		return &NtfyChannel{client, conf.Token}, nil
+	case config.ChannelTypeMatrix:
+		if conf.RoomID == "" || conf.Token == "" {
+			return nil, fmt.Errorf("matrix channel requires room_id and token")
+		}
+		return &MatrixChannel{client, conf.RoomID, conf.Token}, nil
+	default:
+		return nil, fmt.Errorf("unknown channel type %q", conf.Type)
+	}
+}
+
+func NewChannels(conf *config.Config, sender Sender) (map[string]Channel, error) {
+	channels := map[string]Channel{ChannelEmail: &MailChannel{sender, NewActionLinks(conf.HTTP), NewInvites(conf)}}
+	if conf.Webhook.URL != "" {
+		channels[ChannelWebhook] = &WebhookChannel{webhook.NewClient(conf.Webhook)}
+	}
+	for name, channelConf := range conf.Channels {
+		if _, ok := channels[name]; ok || name == ChannelDigest {
+			return nil, fmt.Errorf("channel %q is already defined", name)
+		}
+		channel, err := NewChannel(channelConf)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %w", name, err)
+		}
+		channels[name] = channel
+	}
+	return channels, nil
+}
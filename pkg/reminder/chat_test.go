@@ -0,0 +1,178 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/jbchouinard/mxremind/pkg/webhook"
+)
+
+type capturedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func captureServer(t *testing.T) (*httptest.Server, *capturedRequest) {
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*captured = capturedRequest{r.Method, r.URL.EscapedPath(), r.Header, string(body)}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, captured
+}
+
+func TestChatChannels(t *testing.T) {
+	rem := &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "alice@example.com", Content: "do the thing"}
+
+	server, captured := captureServer(t)
+	slack := &SlackChannel{&webhook.Client{URL: server.URL, HTTP: server.Client()}}
+	if err := slack.Deliver(rem, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal([]byte(captured.body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["text"] != "Reminder: do the thing (for alice@example.com)" {
+		t.Errorf("slack: got %q", payload["text"])
+	}
+
+	server, captured = captureServer(t)
+	ntfy := &NtfyChannel{&webhook.Client{URL: server.URL + "/reminders", HTTP: server.Client()}, "tk"}
+	if err := ntfy.Deliver(rem, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if captured.method != http.MethodPost || captured.path != "/reminders" ||
+		captured.header.Get("Title") != "Reminder: do the thing" ||
+		captured.header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("ntfy: got %s %s %v", captured.method, captured.path, captured.header)
+	}
+
+	server, captured = captureServer(t)
+	matrix := &MatrixChannel{&webhook.Client{URL: server.URL, HTTP: server.Client()}, "!room:example.com", "tk"}
+	if err := matrix.Deliver(rem, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/" + rem.Id.String()
+	if captured.method != http.MethodPut || !strings.HasPrefix(captured.path, prefix) ||
+		!strings.Contains(captured.body, `"msgtype":"m.text"`) {
+		t.Errorf("matrix: got %s %s %s", captured.method, captured.path, captured.body)
+	}
+}
+
+func TestMatrixRedelivery(t *testing.T) {
+	store := testPostgresStore(t)
+	rem := &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "alice@example.com", Content: "deploy", DueTime: time.Now()}
+	server, captured := captureServer(t)
+	matrix := &MatrixChannel{&webhook.Client{URL: server.URL, HTTP: server.Client()}, "!room:example.com", "tk"}
+	deliver := func(f func(dao *ReminderDAO) error) string {
+		err := store.WithTx(context.Background(), func(store ReminderStore) error {
+			dao, err := postgres(store)
+			if err != nil {
+				return err
+			}
+			if err := f(dao); err != nil {
+				return err
+			}
+			rem, err = dao.Load(rem.Id)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := matrix.Deliver(rem, "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		return captured.path
+	}
+
+	first := deliver(func(dao *ReminderDAO) error { return dao.Save(rem) })
+	if again := deliver(func(dao *ReminderDAO) error { return nil }); again != first {
+		t.Errorf("retry: got %s, expected %s", again, first)
+	}
+	snoozed := deliver(func(dao *ReminderDAO) error { return dao.Snooze(rem.Id, rem.DueTime.Add(time.Hour)) })
+	if snoozed == first {
+		t.Errorf("snoozed: got the same path %s", snoozed)
+	}
+	if requeued := deliver(func(dao *ReminderDAO) error { return dao.Requeue(rem.Id) }); requeued == snoozed {
+		t.Errorf("requeued: got the same path %s", requeued)
+	}
+}
+
+// messageRecorder records the messages it sends.
+type messageRecorder struct {
+	messages []*mail.Message
+}
+
+func (r *messageRecorder) Send(to string, subject string, body string) error {
+	return r.SendMessage(&mail.Message{To: to, Subject: subject, Body: body})
+}
+
+func (r *messageRecorder) SendMessage(m *mail.Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// plainSender is only a Sender, which cannot send attachments.
+type plainSender struct {
+	recorder *messageRecorder
+}
+
+func (s plainSender) Send(to string, subject string, body string) error {
+	return s.recorder.Send(to, subject, body)
+}
+
+func TestMailChannelInvites(t *testing.T) {
+	due := time.Date(2023, 4, 6, 15, 0, 0, 0, time.UTC)
+	rem := &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "alice@example.com", Content: "do the thing", DueTime: due}
+	invites := &Invites{Organizer: "reminders@example.com"}
+
+	recorder := &messageRecorder{}
+	if err := (&MailChannel{Sender: recorder, Invites: invites}).Deliver(rem, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	m := recorder.messages[0]
+	if m.To != "bob@example.com" || len(m.Attachments) != 1 {
+		t.Fatalf("got %+v", m)
+	}
+	events, err := ical.Parse(m.Attachments[0].Data, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := events[0]; event.UID != rem.Id.String()+"@mxremind" || !event.Start.Equal(due) ||
+		len(event.Alarms) != 1 || event.Attendees[0] != "bob@example.com" || event.Sequence != 0 {
+		t.Errorf("got %+v", event)
+	}
+
+	rem.Revision = 2
+	cancel := invites.Attachment(ical.MethodCancel, rem, "alice@example.com")
+	events, err = ical.Parse(cancel.Data, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !events[0].IsCancelled() || events[0].UID != rem.Id.String()+"@mxremind" ||
+		events[0].Sequence != 2 || !strings.HasSuffix(cancel.ContentType, "method=CANCEL") {
+		t.Errorf("got %+v", events[0])
+	}
+
+	recorder = &messageRecorder{}
+	if err := (&MailChannel{Sender: plainSender{recorder}, Invites: invites}).Deliver(rem, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.messages[0].Attachments) != 0 {
+		t.Error("expected no attachment with a plain sender")
+	}
+}
@@ -2,6 +2,8 @@ package reminder
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -20,6 +22,14 @@ type ReminderDAO struct {
 // due_time TIMESTAMP,
 // is_sent BOOLEAN
 
+const reminderColumns = `id, generated_from_id, recipient, content, due_time, is_sent,
+	escalate_to, escalate_after, is_acknowledged, is_escalated, tags, priority,
+	is_digested, is_cancelled`
+
+const selectReminderColumns = reminderColumns + `, revision,
+	ARRAY(SELECT rr.recipient FROM reminder_recipients rr
+		WHERE rr.reminder_id = reminders.id ORDER BY rr.recipient)`
+
 func (dao *ReminderDAO) Scan(row pgx.Row) (*Reminder, error) {
 	var rem Reminder
 	err := row.Scan(
@@ -29,6 +39,16 @@ func (dao *ReminderDAO) Scan(row pgx.Row) (*Reminder, error) {
 		&rem.Content,
 		&rem.DueTime,
 		&rem.IsSent,
+		&rem.EscalateTo,
+		&rem.EscalateAfter,
+		&rem.IsAcknowledged,
+		&rem.IsEscalated,
+		&rem.Tags,
+		&rem.Priority,
+		&rem.IsDigested,
+		&rem.IsCancelled,
+		&rem.Revision,
+		&rem.Recipients,
 	)
 	return &rem, err
 }
@@ -36,7 +56,7 @@ func (dao *ReminderDAO) Scan(row pgx.Row) (*Reminder, error) {
 func (dao *ReminderDAO) Load(id uuid.UUID) (*Reminder, error) {
 	return dao.Scan(dao.Tx.QueryRow(
 		dao.Context,
-		`SELECT id, generated_from_id, recipient, content, due_time, is_sent
+		`SELECT `+selectReminderColumns+`
 			FROM reminders
 			WHERE id=$1`,
 		id,
@@ -47,17 +67,42 @@ func (dao *ReminderDAO) Save(rem *Reminder) error {
 	_, err := dao.Tx.Exec(
 		dao.Context,
 		`INSERT INTO reminders
-			(id, generated_from_id, recipient, content, due_time, is_sent)
+			(`+reminderColumns+`)
 			VALUES
-			($1, $2, $3, $4, $5, $6)`,
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
 		rem.Id,
 		rem.GeneratedById,
 		rem.Recipient,
 		rem.Content,
 		rem.DueTime.UTC(),
 		rem.IsSent,
+		rem.EscalateTo,
+		rem.EscalateAfter,
+		rem.IsAcknowledged,
+		rem.IsEscalated,
+		rem.tags(),
+		rem.priority(),
+		rem.IsDigested,
+		rem.IsCancelled,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return dao.saveRecipients(rem)
+}
+
+func (dao *ReminderDAO) saveRecipients(rem *Reminder) error {
+	for _, recipient := range rem.Recipients {
+		if _, err := dao.Tx.Exec(
+			dao.Context,
+			`INSERT INTO reminder_recipients (reminder_id, recipient) VALUES ($1, $2)`,
+			rem.Id,
+			recipient,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (dao *ReminderDAO) Update(rem *Reminder) error {
@@ -68,7 +113,19 @@ func (dao *ReminderDAO) Update(rem *Reminder) error {
 				recipient = $3,
 				content = $4,
 				due_time = $5,
-				is_sent = $6
+				is_sent = $6,
+				escalate_to = $7,
+				escalate_after = $8,
+				is_acknowledged = $9,
+				is_escalated = $10,
+				tags = $11,
+				priority = $12,
+				is_digested = $13,
+				is_cancelled = $14,
+				revision = CASE
+					WHEN content <> $4 OR due_time <> $5 THEN revision + 1
+					ELSE revision
+				END
 			WHERE id = $1`,
 		rem.Id,
 		rem.GeneratedById,
@@ -76,8 +133,26 @@ func (dao *ReminderDAO) Update(rem *Reminder) error {
 		rem.Content,
 		rem.DueTime.UTC(),
 		rem.IsSent,
+		rem.EscalateTo,
+		rem.EscalateAfter,
+		rem.IsAcknowledged,
+		rem.IsEscalated,
+		rem.tags(),
+		rem.priority(),
+		rem.IsDigested,
+		rem.IsCancelled,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if _, err := dao.Tx.Exec(
+		dao.Context,
+		`DELETE FROM reminder_recipients WHERE reminder_id = $1`,
+		rem.Id,
+	); err != nil {
+		return err
+	}
+	return dao.saveRecipients(rem)
 }
 
 func (dao *ReminderDAO) Delete(rem *Reminder) error {
@@ -89,16 +164,401 @@ func (dao *ReminderDAO) Delete(rem *Reminder) error {
 	return err
 }
 
+// Acknowledge marks a sent reminder as acknowledged. Only the recipients of the
+// reminder or its escalation contact can acknowledge it.
+func (dao *ReminderDAO) Acknowledge(id uuid.UUID, by string) error {
+	tag, err := dao.Tx.Exec(
+		dao.Context,
+		`UPDATE reminders
+			SET is_acknowledged = true
+			WHERE id = $1
+			  AND is_sent
+			  AND (lower(recipient) = lower($2) OR lower(escalate_to) = lower($2) OR EXISTS (
+				SELECT 1 FROM reminder_recipients rr
+				WHERE rr.reminder_id = reminders.id AND lower(rr.recipient) = lower($2)))`,
+		id,
+		by,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("no sent reminder %q for %q to acknowledge", id, by)
+	}
+	return nil
+}
+
+func (dao *ReminderDAO) CountActive(recipient string) (int, error) {
+	return dao.count(
+		`SELECT count(*) FROM reminders
+			WHERE lower(recipient) = lower($1)
+			  AND NOT is_sent
+			  AND NOT is_cancelled`,
+		recipient,
+	)
+}
+
+func (dao *ReminderDAO) CountCreatedSince(recipient string, since time.Time) (int, error) {
+	return dao.count(
+		`SELECT count(*) FROM reminders
+			WHERE lower(recipient) = lower($1)
+			  AND created_at >= $2`,
+		recipient,
+		since.UTC(),
+	)
+}
+
+// LockRecipient serializes the transactions sending to a recipient.
+func (dao *ReminderDAO) LockRecipient(recipient string) error {
+	_, err := dao.Tx.Exec(dao.Context, `SELECT pg_advisory_xact_lock(hashtext(lower($1)))`, recipient)
+	return err
+}
+
+// SaveDelivery records a message sent to a recipient, and returns its id.
+func (dao *ReminderDAO) SaveDelivery(id *uuid.UUID, recipient string, channel string, sentAt time.Time) (int64, error) {
+	var deliveryId int64
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`INSERT INTO deliveries (reminder_id, recipient, channel, sent_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		id,
+		recipient,
+		channel,
+		sentAt.UTC(),
+	).Scan(&deliveryId)
+	return deliveryId, err
+}
+
+func (dao *ReminderDAO) DeleteDelivery(deliveryId int64) error {
+	_, err := dao.Tx.Exec(dao.Context, `DELETE FROM deliveries WHERE id = $1`, deliveryId)
+	return err
+}
+
+func (dao *ReminderDAO) CountDeliveriesSince(recipient string, since time.Time) (int, error) {
+	return dao.count(
+		`SELECT count(*) FROM deliveries
+			WHERE lower(recipient) = lower($1)
+			  AND channel = 'email'
+			  AND sent_at >= $2`,
+		recipient,
+		since.UTC(),
+	)
+}
+
+func (dao *ReminderDAO) count(sql string, args ...any) (int, error) {
+	var count int
+	err := dao.Tx.QueryRow(dao.Context, sql, args...).Scan(&count)
+	return count, err
+}
+
 func (dao *ReminderDAO) QueryDue(asOf time.Time) ([]*Reminder, error) {
-	now := time.Now().UTC()
+	return dao.query(
+		`SELECT `+selectReminderColumns+`
+			FROM reminders
+			WHERE due_time <= $1
+			  AND NOT is_sent
+			  AND NOT is_cancelled
+			  AND NOT awaits_digest
+			  AND (held_until IS NULL OR held_until <= $1)`,
+		asOf.UTC(),
+	)
+}
+
+func (dao *ReminderDAO) Hold(id uuid.UUID, until time.Time) error {
+	_, err := dao.Tx.Exec(
+		dao.Context,
+		`UPDATE reminders SET held_until = $2 WHERE id = $1`,
+		id,
+		until.UTC(),
+	)
+	return err
+}
+
+func (dao *ReminderDAO) HoldDelivery(held *HeldDelivery) error {
+	_, err := dao.Tx.Exec(
+		dao.Context,
+		`INSERT INTO held_deliveries (reminder_id, recipient, is_escalation, held_until)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (reminder_id, recipient, is_escalation)
+			DO UPDATE SET held_until = excluded.held_until`,
+		held.ReminderId,
+		held.To,
+		held.IsEscalation,
+		held.Until.UTC(),
+	)
+	return err
+}
+
+func (dao *ReminderDAO) ReleaseHeldDeliveries(asOf time.Time) ([]*HeldDelivery, error) {
+	rows, err := dao.Tx.Query(
+		dao.Context,
+		`DELETE FROM held_deliveries
+			WHERE held_until <= $1
+			RETURNING reminder_id, recipient, is_escalation, held_until`,
+		asOf.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	released := make([]*HeldDelivery, 0)
+	for rows.Next() {
+		var held HeldDelivery
+		if err := rows.Scan(&held.ReminderId, &held.To, &held.IsEscalation, &held.Until); err != nil {
+			return nil, err
+		}
+		released = append(released, &held)
+	}
+	return released, rows.Err()
+}
+
+// AwaitDigest leaves a due reminder for the next digest of its owner.
+func (dao *ReminderDAO) AwaitDigest(id uuid.UUID) error {
+	return dao.exec(id, `UPDATE reminders SET is_sent = false, awaits_digest = true WHERE id = $1`)
+}
+
+// Snooze reschedules a reminder, which is sent again if it already was.
+func (dao *ReminderDAO) Snooze(id uuid.UUID, until time.Time) error {
+	return dao.exec(
+		id,
+		`UPDATE reminders
+			SET due_time = $2,
+				is_sent = false,
+				is_acknowledged = false,
+				is_escalated = false,
+				awaits_digest = false,
+				held_until = NULL,
+				revision = revision + 1
+			WHERE id = $1`,
+		until.UTC(),
+	)
+}
+
+func (dao *ReminderDAO) Cancel(id uuid.UUID) error {
+	return dao.exec(id, `UPDATE reminders SET is_cancelled = true, revision = revision + 1 WHERE id = $1`)
+}
+
+// SetInvited records that the confirmation of a reminder carried its event.
+func (dao *ReminderDAO) SetInvited(id uuid.UUID) error {
+	return dao.exec(id, `UPDATE reminders SET is_invited = true WHERE id = $1`)
+}
+
+func (dao *ReminderDAO) SetInviteCancelled(id uuid.UUID) error {
+	return dao.exec(id, `UPDATE reminders SET is_invite_cancelled = true WHERE id = $1`)
+}
+
+func (dao *ReminderDAO) QueryInviteCancellations() ([]*Reminder, error) {
+	return dao.query(
+		`SELECT ` + selectReminderColumns + `
+			FROM reminders
+			WHERE is_cancelled
+			  AND NOT is_invite_cancelled
+			  AND (is_invited OR EXISTS (
+				SELECT 1 FROM deliveries d
+				WHERE d.reminder_id = reminders.id AND d.channel = 'email'))`,
+	)
+}
+
+// InviteRecipients returns the addresses that may have the event of a reminder.
+func (dao *ReminderDAO) InviteRecipients(id uuid.UUID) ([]string, error) {
 	rows, err := dao.Tx.Query(
 		dao.Context,
-		`SELECT id, generated_from_id, recipient, content, due_time, is_sent
+		`SELECT lower(recipient) FROM reminders WHERE id = $1 AND is_invited
+			UNION
+			SELECT lower(recipient) FROM deliveries WHERE reminder_id = $1 AND channel = 'email'
+			ORDER BY 1`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recipients := make([]string, 0)
+	for rows.Next() {
+		var recipient string
+		if err := rows.Scan(&recipient); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, rows.Err()
+}
+
+// exec fails if there is no reminder with the id.
+func (dao *ReminderDAO) exec(id uuid.UUID, sql string, args ...any) error {
+	tag, err := dao.Tx.Exec(dao.Context, sql, append([]any{id}, args...)...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("no reminder %q", id)
+	}
+	return nil
+}
+
+// The escalation delay of held reminders starts when they are released.
+func (dao *ReminderDAO) QueryEscalationDue(asOf time.Time) ([]*Reminder, error) {
+	return dao.query(
+		`SELECT `+selectReminderColumns+`
 			FROM reminders
-			WHERE due_time <= $1
-			  AND NOT is_sent`,
-		now,
+			WHERE is_sent
+			  AND NOT is_cancelled
+			  AND escalate_to <> ''
+			  AND NOT is_acknowledged
+			  AND NOT is_escalated
+			  AND COALESCE(held_until, due_time) + escalate_after <= $1`,
+		asOf.UTC(),
+	)
+}
+
+func (dao *ReminderDAO) QueryRecipient(recipient string) ([]*Reminder, error) {
+	return dao.query(
+		`SELECT `+selectReminderColumns+`
+			FROM reminders
+			WHERE lower(recipient) = lower($1)
+			ORDER BY due_time`,
+		recipient,
+	)
+}
+
+func (dao *ReminderDAO) QueryPending(recipient string) ([]*Reminder, error) {
+	return dao.query(
+		`SELECT `+selectReminderColumns+`
+			FROM reminders
+			WHERE lower(recipient) = lower($1)
+			  AND NOT is_sent
+			  AND NOT is_cancelled
+			ORDER BY due_time`,
+		recipient,
+	)
+}
+
+func (dao *ReminderDAO) DeliveredIds(recipient string) (map[uuid.UUID]bool, error) {
+	rows, err := dao.Tx.Query(
+		dao.Context,
+		`SELECT DISTINCT d.reminder_id
+			FROM deliveries d
+			JOIN reminders r ON r.id = d.reminder_id
+			WHERE lower(r.recipient) = lower($1)`,
+		recipient,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// Reminders with an escalation or other recipients are not listed in digests.
+func (dao *ReminderDAO) QueryDigest(recipient string, before time.Time) ([]*Reminder, error) {
+	return dao.query(
+		`SELECT `+selectReminderColumns+`
+			FROM reminders
+			WHERE lower(recipient) = lower($1)
+			  AND due_time < $2
+			  AND NOT is_sent
+			  AND NOT is_cancelled
+			  AND escalate_to = ''
+			  AND NOT EXISTS (
+				SELECT 1 FROM reminder_recipients rr WHERE rr.reminder_id = reminders.id)
+			ORDER BY due_time`,
+		recipient,
+		before.UTC(),
+	)
+}
+
+// Zero fields of a Filter select all reminders.
+type Filter struct {
+	Recipient string
+	Status    string
+	DueAfter  time.Time
+	DueBefore time.Time
+	Limit     int
+}
+
+var statusConditions = map[string]string{
+	StatusPending:   "NOT is_sent AND NOT is_cancelled",
+	StatusSent:      "is_sent AND NOT is_cancelled",
+	StatusCancelled: "is_cancelled",
+}
+
+func (dao *ReminderDAO) QueryFilter(f *Filter) ([]*Reminder, error) {
+	sql, args, err := f.query()
+	if err != nil {
+		return nil, err
+	}
+	return dao.query(sql, args...)
+}
+
+func (f *Filter) query() (string, []any, error) {
+	conditions := []string{"true"}
+	args := make([]any, 0)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Recipient != "" {
+		p := arg(f.Recipient)
+		conditions = append(conditions, `(lower(recipient) = lower(`+p+`) OR EXISTS (
+				SELECT 1 FROM reminder_recipients rr
+				WHERE rr.reminder_id = reminders.id AND lower(rr.recipient) = lower(`+p+`)))`)
+	}
+	if f.Status != "" {
+		condition, ok := statusConditions[f.Status]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown status %q", f.Status)
+		}
+		conditions = append(conditions, condition)
+	}
+	if !f.DueAfter.IsZero() {
+		conditions = append(conditions, "due_time >= "+arg(f.DueAfter.UTC()))
+	}
+	if !f.DueBefore.IsZero() {
+		conditions = append(conditions, "due_time < "+arg(f.DueBefore.UTC()))
+	}
+	sql := `SELECT ` + selectReminderColumns + `
+			FROM reminders
+			WHERE ` + strings.Join(conditions, " AND ") + `
+			ORDER BY due_time`
+	if f.Limit > 0 {
+		sql += " LIMIT " + arg(f.Limit)
+	}
+	return sql, args, nil
+}
+
+func (dao *ReminderDAO) Requeue(id uuid.UUID) error {
+	return dao.exec(
+		id,
+		`UPDATE reminders
+			SET is_sent = false,
+				is_acknowledged = false,
+				is_escalated = false,
+				is_digested = false,
+				awaits_digest = false,
+				held_until = NULL,
+				revision = revision + 1
+			WHERE id = $1`,
+	)
+}
+
+func (dao *ReminderDAO) Purge(before time.Time) (int64, error) {
+	tag, err := dao.Tx.Exec(
+		dao.Context,
+		`DELETE FROM reminders WHERE (is_sent OR is_cancelled) AND due_time < $1`,
+		before.UTC(),
 	)
+	return tag.RowsAffected(), err
+}
+
+func (dao *ReminderDAO) query(sql string, args ...any) ([]*Reminder, error) {
+	rows, err := dao.Tx.Query(dao.Context, sql, args...)
 	if err != nil {
 		return nil, err
 	}
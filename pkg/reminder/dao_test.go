@@ -0,0 +1,96 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestFilterQuery(t *testing.T) {
+	after := time.Date(2023, 4, 5, 10, 0, 0, 0, time.UTC)
+	sql, args, err := (&Filter{Recipient: "bob@example.com", Status: StatusSent, DueAfter: after, Limit: 10}).query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"lower(rr.recipient) = lower($1)", statusConditions[StatusSent], "due_time >= $2", "LIMIT $3"} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("%q is not in %s", part, sql)
+		}
+	}
+	if strings.Contains(sql, "due_time <") {
+		t.Errorf("got a due-before condition in %s", sql)
+	}
+	if len(args) != 3 || args[0] != "bob@example.com" || args[2] != 10 {
+		t.Errorf("got args %v", args)
+	}
+	if _, _, err := (&Filter{Status: "lost"}).query(); err == nil {
+		t.Error("expected error for unknown status")
+	}
+}
+
+// errRollback rolls back the transaction of a test.
+var errRollback = errors.New("rollback")
+
+func TestQueryFilterAndPurge(t *testing.T) {
+	store := testPostgresStore(t)
+	owner := uuid.Must(uuid.NewV4()).String() + "@example.com"
+	due := time.Date(2023, 4, 5, 10, 0, 0, 0, time.UTC)
+	reminders := []*Reminder{
+		{Id: uuid.Must(uuid.NewV4()), Recipient: owner, Content: "sent", DueTime: due, IsSent: true},
+		{Id: uuid.Must(uuid.NewV4()), Recipient: owner, Content: "cancelled", DueTime: due.Add(time.Hour), IsCancelled: true},
+		{Id: uuid.Must(uuid.NewV4()), Recipient: owner, Content: "pending", DueTime: due.Add(2 * time.Hour)},
+		{Id: uuid.Must(uuid.NewV4()), Recipient: "carol@example.com", Recipients: []string{owner}, Content: "shared", DueTime: due},
+	}
+	err := store.WithTx(context.Background(), func(store ReminderStore) error {
+		dao, err := postgres(store)
+		if err != nil {
+			return err
+		}
+		for _, rem := range reminders {
+			if err := dao.Save(rem); err != nil {
+				return err
+			}
+		}
+		contents := func(f *Filter) string {
+			found, err := dao.QueryFilter(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list := make([]string, 0, len(found))
+			for _, rem := range found {
+				list = append(list, rem.Content)
+			}
+			return strings.Join(list, " ")
+		}
+		if got := contents(&Filter{Recipient: owner}); got != "sent shared cancelled pending" && got != "shared sent cancelled pending" {
+			t.Errorf("got %q for the recipient", got)
+		}
+		if got := contents(&Filter{Recipient: owner, Status: StatusPending, DueAfter: due.Add(time.Minute)}); got != "pending" {
+			t.Errorf("got %q pending after the due time", got)
+		}
+		if got := contents(&Filter{Recipient: owner, DueBefore: due.Add(90 * time.Minute), Limit: 1}); got != "sent" && got != "shared" {
+			t.Errorf("got %q with a limit", got)
+		}
+
+		// The test database can hold reminders of other tests, which are
+		// purged too until the transaction is rolled back.
+		n, err := dao.Purge(due.Add(3 * time.Hour))
+		if err != nil {
+			return err
+		}
+		if n < 2 {
+			t.Errorf("purged %d reminders, expected at least 2", n)
+		}
+		if got := contents(&Filter{Recipient: owner}); got != "shared pending" {
+			t.Errorf("got %q after purge", got)
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatal(err)
+	}
+}
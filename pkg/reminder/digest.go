@@ -0,0 +1,201 @@
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/rs/zerolog/log"
+)
+
+// Schedule returns the last scheduled time at or before now, and the next one.
+func (s *DigestSettings) Schedule(now time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	clock, err := time.Parse("15:04", s.Time)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	local := now.In(loc)
+	at := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	days := 1
+	if s.Mode == DigestWeekly {
+		days = 7
+		at = at.AddDate(0, 0, -((int(local.Weekday()) - int(s.Weekday) + 7) % 7))
+	}
+	if at.After(now) {
+		at = at.AddDate(0, 0, -days)
+	}
+	return at, at.AddDate(0, 0, days), nil
+}
+
+func (s *DigestSettings) Location(def *time.Location) *time.Location {
+	return loadLocation(s.Email, s.Timezone, def)
+}
+
+var agendaTemplate = template.Must(template.New("agenda").Parse(
+	`{{range .Days}}{{.Date}}
+{{range .Reminders}}  {{.DueTime.Format "15:04"}}  {{.Content}}{{if ne .Priority "normal"}} !{{.Priority}}{{end}}{{range .Tags}} #{{.}}{{end}}
+{{end}}
+{{end}}`))
+
+type agendaDay struct {
+	Date      string
+	Reminders []*Reminder
+}
+
+func Agenda(mode string, start time.Time, reminders []*Reminder, loc *time.Location) (string, string, error) {
+	subject := "Agenda for " + start.In(loc).Format("Monday, January 2")
+	if mode == DigestWeekly {
+		subject = "Agenda for the week of " + start.In(loc).Format("January 2")
+	}
+	days := make([]*agendaDay, 0)
+	for _, rem := range reminders {
+		local := *rem
+		local.DueTime = rem.DueTime.In(loc)
+		local.Priority = rem.priority()
+		date := local.DueTime.Format("Monday, January 2")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, &agendaDay{Date: date})
+		}
+		day := days[len(days)-1]
+		day.Reminders = append(day.Reminders, &local)
+	}
+	var body strings.Builder
+	if err := agendaTemplate.Execute(&body, map[string]any{"Days": days}); err != nil {
+		return "", "", err
+	}
+	return subject, strings.ReplaceAll(strings.TrimSpace(body.String()), "\n", "\r\n"), nil
+}
+
+// Listed reminders are marked as sent, so they are not delivered individually.
+type DigestScheduler struct {
+	Pool     *pgxpool.Pool
+	Done     <-chan bool
+	Sender   Sender
+	Quota    *Quota
+	Location *time.Location
+	Errors   chan<- error
+	Interval time.Duration
+}
+
+func NewDigestScheduler(
+	interval time.Duration, pool *pgxpool.Pool, sender Sender, quota *Quota, loc *time.Location, done <-chan bool,
+) (*DigestScheduler, <-chan error) {
+	errors := make(chan error, 1)
+	return &DigestScheduler{pool, done, sender, quota, loc, errors, interval}, errors
+}
+
+func (ds *DigestScheduler) RunOnce() {
+	ctx := context.Background()
+	tx, err := ds.Pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		ds.Errors <- err
+		return
+	}
+	users := UserDAO{Tx: tx, Context: ctx}
+	settings, err := users.DigestSettings()
+	tx.Rollback(ctx)
+	if err != nil {
+		ds.Errors <- err
+		return
+	}
+	now := time.Now()
+	for _, s := range settings {
+		if err := ds.send(s, now); err != nil {
+			ds.Errors <- fmt.Errorf("error sending digest to %q: %w", s.Email, err)
+		}
+	}
+}
+
+func (ds *DigestScheduler) send(s *DigestSettings, now time.Time) error {
+	loc := s.Location(ds.Location)
+	start, end, err := s.Schedule(now, loc)
+	if err != nil {
+		return err
+	}
+	if s.LastDigestAt != nil && !s.LastDigestAt.Before(start) {
+		return nil
+	}
+	ctx := context.Background()
+	var reminders []*Reminder
+	var deliveryId int64
+	// The digest is sent once the reminders are marked sent, so that it is not
+	// sent twice if a transaction fails; they are put back if sending fails.
+	err = db.WithTx(ctx, ds.Pool, func(tx pgx.Tx) error {
+		dao := &ReminderDAO{Tx: tx, Context: ctx}
+		reminders, err = dao.QueryDigest(s.Email, end)
+		if err != nil {
+			return err
+		}
+		if len(reminders) == 0 {
+			users := UserDAO{Tx: tx, Context: ctx}
+			return users.SaveDigestSent(s.Email, now)
+		}
+		if err := ds.Quota.CheckSend(dao, s.Email); err != nil {
+			return err
+		}
+		for _, rem := range reminders {
+			rem.IsSent = true
+			rem.IsDigested = true
+			if err := dao.Update(rem); err != nil {
+				return err
+			}
+		}
+		deliveryId, err = dao.SaveDelivery(nil, s.Email, ChannelEmail, now)
+		return err
+	})
+	if err != nil || len(reminders) == 0 {
+		return err
+	}
+	subject, body, err := Agenda(s.Mode, start, reminders, loc)
+	if err == nil {
+		err = ds.Sender.Send(s.Email, subject, body)
+	}
+	if err != nil {
+		ds.putBack(reminders, deliveryId)
+		return err
+	}
+	log.Info().Msgf("sent digest of %d reminders to %q", len(reminders), s.Email)
+	return db.WithTx(ctx, ds.Pool, func(tx pgx.Tx) error {
+		users := UserDAO{Tx: tx, Context: ctx}
+		return users.SaveDigestSent(s.Email, now)
+	})
+}
+
+func (ds *DigestScheduler) putBack(reminders []*Reminder, deliveryId int64) {
+	ctx := context.Background()
+	err := db.WithTx(ctx, ds.Pool, func(tx pgx.Tx) error {
+		dao := &ReminderDAO{Tx: tx, Context: ctx}
+		for _, rem := range reminders {
+			rem.IsSent = false
+			rem.IsDigested = false
+			if err := dao.Update(rem); err != nil {
+				return err
+			}
+		}
+		return dao.DeleteDelivery(deliveryId)
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("error putting back the reminders of a failed digest")
+	}
+}
+
+func (ds *DigestScheduler) Close() {
+	close(ds.Errors)
+}
+
+func (ds *DigestScheduler) Run() {
+	defer ds.Close()
+	for {
+		select {
+		case <-ds.Done:
+			return
+		case <-time.After(ds.Interval):
+		}
+		ds.RunOnce()
+	}
+}
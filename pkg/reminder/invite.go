@@ -0,0 +1,83 @@
+package reminder
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/rs/zerolog/log"
+)
+
+// InviteLead applies to events without alarms.
+const InviteLead = 15 * time.Minute
+
+func inviteContent(event *ical.Event, loc *time.Location) string {
+	summary := event.Summary
+	if summary == "" {
+		summary = "(no title)"
+	}
+	if event.AllDay {
+		return summary + " (" + event.Start.Format("Mon 2 Jan") + ")"
+	}
+	return summary + " (" + event.Start.In(loc).Format("Mon 2 Jan 15:04") + ")"
+}
+
+// Cancelled events and alarms before now are skipped.
+func RemindersFromInvite(m *mail.Mail, policy *RecipientPolicy, now time.Time) ([]*Reminder, error) {
+	recipients, denied := policy.Filter(m.From, append(append([]string{}, m.To...), m.Cc...))
+	for _, address := range denied {
+		log.Warn().Msgf("%s (id=%s): recipient %q is not allowed", m.From, m.MessageId, address)
+	}
+	reminders := make([]*Reminder, 0)
+	for _, event := range m.Events {
+		if event.IsCancelled() {
+			log.Info().Msgf("%s (id=%s): skipping cancelled event %q", m.From, m.MessageId, event.UID)
+			continue
+		}
+		alarms := event.Alarms
+		if len(alarms) == 0 {
+			alarms = []time.Time{event.Start.Add(-InviteLead)}
+		}
+		seen := make(map[time.Time]bool)
+		for _, dueTime := range alarms {
+			dueTime = dueTime.UTC()
+			if seen[dueTime] || dueTime.Before(now) {
+				continue
+			}
+			seen[dueTime] = true
+			reminders = append(reminders, &Reminder{
+				Id:            uuid.Must(uuid.NewV1()),
+				GeneratedById: m.MessageId,
+				DueTime:       dueTime,
+				Recipient:     m.From,
+				Recipients:    recipients,
+				Content:       inviteContent(event, m.Location),
+			})
+		}
+	}
+	if len(reminders) == 0 {
+		return nil, errors.New("invite has no upcoming events")
+	}
+	return reminders, nil
+}
+
+func CommandsFromMail(m *mail.Mail, policy *RecipientPolicy) ([]Command, error) {
+	if len(m.Events) == 0 || regexAckTag.MatchString(m.Subject) {
+		cmd, err := CommandFromMail(m, policy)
+		if err != nil {
+			return nil, err
+		}
+		return []Command{cmd}, nil
+	}
+	reminders, err := RemindersFromInvite(m, policy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]Command, 0, len(reminders))
+	for _, rem := range reminders {
+		commands = append(commands, &NewReminder{Reminder: rem})
+	}
+	return commands, nil
+}
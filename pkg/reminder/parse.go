@@ -3,7 +3,9 @@ package reminder
 import (
 	"errors"
 	"regexp"
+	"strings"
 	"time"
+	"unicode"
 )
 
 var regexTime = regexp.MustCompile(`(\d\d:\d\d) (.*)`)
@@ -11,14 +13,17 @@ var regexTomorrow = regexp.MustCompile(`tomorrow (\d\d:\d\d) (.*)`)
 var regexDate = regexp.MustCompile(`(\d\d/\d\d \d\d:\d\d) (.*)`)
 var regexYearDate = regexp.MustCompile(`(\d\d\d\d-\d\d-\d\d \d\d:\d\d) (.*)`)
 
+// timeSpecs are tried in order.
 var timeSpecs = []struct {
-	regex *regexp.Regexp
-	f     func(string, *time.Location) (time.Time, error)
+	format string
+	regex  *regexp.Regexp
+	f      func(string, time.Time) (time.Time, error)
 }{
 	{
+		"tomorrow HH:MM",
 		regexTomorrow,
-		func(s string, loc *time.Location) (time.Time, error) {
-			t, err := parseLocalTime(s, loc)
+		func(s string, now time.Time) (time.Time, error) {
+			t, err := parseLocalTime(s, now)
 			tplus1 := t.AddDate(0, 0, 1)
 			if err != nil {
 				return time.Time{}, err
@@ -27,48 +32,49 @@ var timeSpecs = []struct {
 		},
 	},
 	{
+		"MM/DD HH:MM",
 		regexDate,
 		parseLocalDateTime,
 	},
 	{
+		"YYYY-MM-DD HH:MM",
 		regexYearDate,
 		parseLocalYearDateTime,
 	},
 	{
+		"HH:MM",
 		regexTime,
 		parseLocalTime,
 	},
 }
 
-func parseLocalTime(s string, loc *time.Location) (time.Time, error) {
+func parseLocalTime(s string, now time.Time) (time.Time, error) {
 	localTime, err := time.Parse("15:04", s)
 	if err != nil {
 		return localTime, err
 	}
-	now := time.Now().In(loc)
 	dateTime := time.Date(
 		now.Year(), now.Month(), now.Day(),
 		localTime.Hour(), localTime.Minute(),
-		0, 0, loc,
+		0, 0, now.Location(),
 	)
 	return dateTime, nil
 }
 
-func parseLocalDateTime(s string, loc *time.Location) (time.Time, error) {
+func parseLocalDateTime(s string, now time.Time) (time.Time, error) {
 	localTime, err := time.Parse("01/02 15:04", s)
-	now := time.Now().In(loc)
 	if err != nil {
 		return localTime, err
 	}
 	dateTime := time.Date(
 		now.Year(), localTime.Month(), localTime.Day(),
 		localTime.Hour(), localTime.Minute(),
-		0, 0, loc,
+		0, 0, now.Location(),
 	)
 	return dateTime, nil
 }
 
-func parseLocalYearDateTime(s string, loc *time.Location) (time.Time, error) {
+func parseLocalYearDateTime(s string, now time.Time) (time.Time, error) {
 	localTime, err := time.Parse("2006-01-02 15:04", s)
 	if err != nil {
 		return localTime, err
@@ -76,20 +82,101 @@ func parseLocalYearDateTime(s string, loc *time.Location) (time.Time, error) {
 	dateTime := time.Date(
 		localTime.Year(), localTime.Month(), localTime.Day(),
 		localTime.Hour(), localTime.Minute(),
-		0, 0, loc,
+		0, 0, now.Location(),
 	)
 	return dateTime, nil
 }
 
-func parseSpec(s string, loc *time.Location) (time.Time, string, error) {
+func parseSpec(s string, now time.Time) (time.Time, string, string, error) {
 	for _, spec := range timeSpecs {
 		if m := spec.regex.FindStringSubmatch(s); m != nil {
-			dueTime, err := spec.f(m[1], loc)
+			dueTime, err := spec.f(m[1], now)
 			if err != nil {
-				return time.Time{}, "", err
+				return time.Time{}, "", spec.format, err
 			}
-			return dueTime, m[2], nil
+			return dueTime, m[2], spec.format, nil
 		}
 	}
-	return time.Time{}, "", errors.New("not a valid reminder spec")
+	return time.Time{}, "", "", errors.New("not a valid reminder spec")
+}
+
+func SpecFormat(subject string) string {
+	_, _, format, _ := parseSpec(subject, time.Now())
+	return format
+}
+
+var regexEscalation = regexp.MustCompile(`\s*\bescalate:(\S+@\S+) after (\S+)`)
+
+func parseEscalation(s string) (string, string, time.Duration, error) {
+	m := regexEscalation.FindStringSubmatchIndex(s)
+	if m == nil {
+		return s, "", 0, nil
+	}
+	address := s[m[2]:m[3]]
+	delay, err := time.ParseDuration(s[m[4]:m[5]])
+	if err != nil {
+		return "", "", 0, err
+	}
+	if delay <= 0 {
+		return "", "", 0, errors.New("escalation delay must be positive")
+	}
+	content := strings.TrimSpace(s[:m[0]] + s[m[1]:])
+	return content, address, delay, nil
+}
+
+var regexMention = regexp.MustCompile(`(?:^|\s)@(\S+@\S+)`)
+
+func parseMentions(s string) (string, []string) {
+	return extractWords(regexMention, s)
+}
+
+var regexTag = regexp.MustCompile(`(?:^|\s)#([\w-]+)`)
+
+func parseTags(s string) (string, []string) {
+	content, tags := extractWords(regexTag, s)
+	for i, tag := range tags {
+		tags[i] = strings.ToLower(tag)
+	}
+	return content, tags
+}
+
+var regexPriority = regexp.MustCompile(`(?i)(?:^|\s)!(low|normal|high|urgent)\b`)
+
+func parsePriority(s string) (string, string) {
+	content, priorities := extractWords(regexPriority, s)
+	if len(priorities) == 0 {
+		return s, PriorityNormal
+	}
+	return content, strings.ToLower(priorities[len(priorities)-1])
+}
+
+func IsPriority(s string) bool {
+	switch s {
+	case PriorityLow, PriorityNormal, PriorityHigh, PriorityUrgent:
+		return true
+	}
+	return false
+}
+
+// Content ends up in mail subjects, so it must not contain line breaks.
+func CheckContent(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return errors.New("content is empty")
+	}
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return errors.New("content contains control characters")
+	}
+	return nil
+}
+
+func extractWords(regex *regexp.Regexp, s string) (string, []string) {
+	words := make([]string, 0)
+	for _, m := range regex.FindAllStringSubmatch(s, -1) {
+		words = append(words, m[1])
+	}
+	if len(words) == 0 {
+		return s, words
+	}
+	content := strings.Join(strings.Fields(regex.ReplaceAllString(s, " ")), " ")
+	return content, words
 }
@@ -0,0 +1,186 @@
+package reminder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/rs/zerolog/log"
+)
+
+// AddressList entries are addresses, "@domain"s or "/regex/"es matching whole addresses.
+type AddressList struct {
+	addresses map[string]bool
+	domains   map[string]bool
+	patterns  []*regexp.Regexp
+}
+
+func NewAddressList(entries []string) (*AddressList, error) {
+	list := &AddressList{make(map[string]bool), make(map[string]bool), nil}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+			pattern, err := regexp.Compile("(?i)^(?:" + entry[1:len(entry)-1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid address pattern %q: %w", entry, err)
+			}
+			list.patterns = append(list.patterns, pattern)
+		case strings.HasPrefix(entry, "@"):
+			list.domains[strings.ToLower(entry[1:])] = true
+		case entry != "":
+			list.addresses[strings.ToLower(entry)] = true
+		}
+	}
+	return list, nil
+}
+
+func (l *AddressList) Empty() bool {
+	return len(l.addresses) == 0 && len(l.domains) == 0 && len(l.patterns) == 0
+}
+
+func (l *AddressList) Match(address string) bool {
+	address = strings.ToLower(address)
+	if l.addresses[address] {
+		return true
+	}
+	if i := strings.LastIndex(address, "@"); i >= 0 && l.domains[address[i+1:]] {
+		return true
+	}
+	for _, pattern := range l.patterns {
+		if pattern.MatchString(address) {
+			return true
+		}
+	}
+	return false
+}
+
+type RecipientPolicy struct {
+	Mailbox string
+	Allow   *AddressList
+}
+
+// Filter drops the sender, the reminders mailbox and duplicates.
+func (p *RecipientPolicy) Filter(sender string, candidates []string) ([]string, []string) {
+	allowed := make([]string, 0)
+	denied := make([]string, 0)
+	seen := map[string]bool{
+		strings.ToLower(sender):    true,
+		strings.ToLower(p.Mailbox): true,
+	}
+	for _, address := range candidates {
+		key := strings.ToLower(address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if p.Allow.Match(address) {
+			allowed = append(allowed, address)
+		} else {
+			denied = append(denied, address)
+		}
+	}
+	return allowed, denied
+}
+
+func (p *RecipientPolicy) AllowEscalation(sender string, address string) bool {
+	if strings.EqualFold(sender, address) {
+		return true
+	}
+	allowed, _ := p.Filter(sender, []string{address})
+	return len(allowed) == 1
+}
+
+// The deny list has precedence; an empty allow list allows every sender.
+type SenderPolicy struct {
+	Allow *AddressList
+	Deny  *AddressList
+}
+
+func (p *SenderPolicy) Check(sender string) error {
+	if p.Deny.Match(sender) {
+		return &RejectionError{"sender is denied"}
+	}
+	if !p.Allow.Empty() && !p.Allow.Match(sender) {
+		return &RejectionError{"sender is not allowed"}
+	}
+	return nil
+}
+
+type AuthPolicy struct {
+	Require []string
+}
+
+func (p *AuthPolicy) Check(m *mail.Mail) error {
+	for _, method := range p.Require {
+		if result := m.Auth[method]; result != "pass" {
+			if result == "" {
+				result = "none"
+			}
+			return &RejectionError{fmt.Sprintf("%s authentication result is %s", method, result)}
+		}
+	}
+	return nil
+}
+
+type Policy struct {
+	Auth       *AuthPolicy
+	Senders    *SenderPolicy
+	Recipients *RecipientPolicy
+}
+
+func NewPolicy(conf *config.Config) (*Policy, error) {
+	allowSenders, err := NewAddressList(conf.Senders.Allow)
+	if err != nil {
+		return nil, err
+	}
+	denySenders, err := NewAddressList(conf.Senders.Deny)
+	if err != nil {
+		return nil, err
+	}
+	allowRecipients, err := NewAddressList(conf.Recipients.Allow)
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range conf.Auth.Require {
+		switch method {
+		case mail.AuthDKIM, mail.AuthSPF, mail.AuthDMARC:
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q", method)
+		}
+	}
+	return &Policy{
+		Auth:    &AuthPolicy{Require: conf.Auth.Require},
+		Senders: &SenderPolicy{Allow: allowSenders, Deny: denySenders},
+		Recipients: &RecipientPolicy{
+			Mailbox: conf.Mailbox.Address,
+			Allow:   allowRecipients,
+		},
+	}, nil
+}
+
+func (p *Policy) Check(m *mail.Mail) error {
+	if err := p.Auth.Check(m); err != nil {
+		return err
+	}
+	return p.Senders.Check(m.From)
+}
+
+type RejectionError struct {
+	Reason string
+}
+
+func (err *RejectionError) Error() string {
+	return err.Reason
+}
+
+func logRejection(m *mail.Mail, err error) {
+	log.Warn().
+		Str("event", "mail_rejected").
+		Str("from", m.From).
+		Str("message_id", m.MessageId).
+		Str("reason", err.Error()).
+		Msg("rejected mail")
+}
@@ -0,0 +1,76 @@
+package reminder
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Start and End are local "HH:MM" times, and may wrap past midnight.
+type QuietHours struct {
+	Timezone string
+	Start    string
+	End      string
+	Weekends bool
+}
+
+type HeldDelivery struct {
+	ReminderId   uuid.UUID
+	To           string
+	IsEscalation bool
+	Until        time.Time
+}
+
+// Quiet hours are in the local time of loc, so they follow DST changes.
+func (q *QuietHours) HoldUntil(t time.Time, loc *time.Location) (time.Time, error) {
+	nightly := q.Start != "" && q.End != "" && q.Start != q.End
+	var start, end time.Time
+	if nightly {
+		var err error
+		if start, err = time.Parse("15:04", q.Start); err != nil {
+			return t, err
+		}
+		if end, err = time.Parse("15:04", q.End); err != nil {
+			return t, err
+		}
+	}
+	until := t.In(loc)
+	// A night can be followed by a weekend.
+	for i := 0; i < 4; i++ {
+		next := until
+		if q.Weekends && (until.Weekday() == time.Saturday || until.Weekday() == time.Sunday) {
+			days := 1
+			if until.Weekday() == time.Saturday {
+				days = 2
+			}
+			next = time.Date(until.Year(), until.Month(), until.Day()+days, 0, 0, 0, 0, loc)
+		} else if nightly {
+			next = nightEnd(until, start, end, loc)
+		}
+		if next.Equal(until) {
+			break
+		}
+		until = next
+	}
+	return until, nil
+}
+
+func nightEnd(t time.Time, start time.Time, end time.Time, loc *time.Location) time.Time {
+	clock := t.Hour()*60 + t.Minute()
+	startClock := start.Hour()*60 + start.Minute()
+	endClock := end.Hour()*60 + end.Minute()
+	endToday := time.Date(t.Year(), t.Month(), t.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+	if startClock < endClock {
+		if clock >= startClock && clock < endClock {
+			return endToday
+		}
+		return t
+	}
+	if clock >= startClock {
+		return endToday.AddDate(0, 0, 1)
+	}
+	if clock < endClock {
+		return endToday
+	}
+	return t
+}
@@ -0,0 +1,77 @@
+package reminder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+)
+
+type Quota struct {
+	Defaults *Limits
+}
+
+func NewQuota(conf *config.LimitsConfig) *Quota {
+	return &Quota{Defaults: &Limits{
+		ActiveReminders:  conf.ActiveReminders,
+		RemindersPerHour: conf.RemindersPerHour,
+		MailsPerDay:      conf.MailsPerDay,
+	}}
+}
+
+type QuotaError struct {
+	Limit string
+	Max   int
+}
+
+func (err *QuotaError) Error() string {
+	return fmt.Sprintf("limit of %d %s reached", err.Max, err.Limit)
+}
+
+func (q *Quota) CheckNew(store ReminderStore, rem *Reminder) error {
+	limits, err := store.Users().Limits(rem.Recipient, q.Defaults)
+	if err != nil {
+		return err
+	}
+	if limits.ActiveReminders > 0 {
+		count, err := store.CountActive(rem.Recipient)
+		if err != nil {
+			return err
+		}
+		if count >= limits.ActiveReminders {
+			return &QuotaError{"active reminders", limits.ActiveReminders}
+		}
+	}
+	if limits.RemindersPerHour > 0 {
+		count, err := store.CountCreatedSince(rem.Recipient, time.Now().Add(-time.Hour))
+		if err != nil {
+			return err
+		}
+		if count >= limits.RemindersPerHour {
+			return &QuotaError{"new reminders per hour", limits.RemindersPerHour}
+		}
+	}
+	return nil
+}
+
+// The recipient stays locked until the end of the transaction, so that
+// concurrent checks count a delivery saved after this one.
+func (q *Quota) CheckSend(store ReminderStore, recipient string) error {
+	if err := store.LockRecipient(recipient); err != nil {
+		return err
+	}
+	limits, err := store.Users().Limits(recipient, q.Defaults)
+	if err != nil {
+		return err
+	}
+	if limits.MailsPerDay > 0 {
+		count, err := store.CountDeliveriesSince(recipient, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			return err
+		}
+		if count >= limits.MailsPerDay {
+			return &QuotaError{"mails per day", limits.MailsPerDay}
+		}
+	}
+	return nil
+}
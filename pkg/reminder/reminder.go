@@ -2,12 +2,14 @@ package reminder
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jbchouinard/mxremind/pkg/mail"
 	"github.com/rs/zerolog/log"
 )
@@ -23,39 +25,230 @@ func (PrintSender) Send(to string, subject string, body string) error {
 	return nil
 }
 
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
 type Reminder struct {
-	Id            uuid.UUID
-	GeneratedById string
-	DueTime       time.Time
-	Recipient     string
-	Content       string
-	IsSent        bool
+	Id             uuid.UUID
+	GeneratedById  string
+	DueTime        time.Time
+	Recipient      string
+	Recipients     []string
+	Content        string
+	IsSent         bool
+	EscalateTo     string
+	EscalateAfter  time.Duration
+	IsAcknowledged bool
+	IsEscalated    bool
+	Tags           []string
+	Priority       string
+	IsDigested     bool
+	IsCancelled    bool
+	Revision       int
+	deliverTo      []string
+}
+
+func (rem *Reminder) AllRecipients() []string {
+	return append([]string{rem.Recipient}, rem.Recipients...)
+}
+
+// deliveryRecipients excludes the recipients in their quiet hours.
+func (rem *Reminder) deliveryRecipients() []string {
+	if rem.deliverTo != nil {
+		return rem.deliverTo
+	}
+	return rem.AllRecipients()
 }
 
 func (rem *Reminder) SendWith(sender Sender) error {
-	return sender.Send(rem.Recipient, rem.Content, "")
+	for _, to := range rem.AllRecipients() {
+		if err := sender.Send(to, rem.Subject(), rem.Body()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	StatusPending   = "pending"
+	StatusSent      = "sent"
+	StatusCancelled = "cancelled"
+)
+
+func (rem *Reminder) Status() string {
+	switch {
+	case rem.IsCancelled:
+		return StatusCancelled
+	case rem.IsSent:
+		return StatusSent
+	default:
+		return StatusPending
+	}
+}
+
+func (rem *Reminder) tags() []string {
+	if rem.Tags == nil {
+		return []string{}
+	}
+	return rem.Tags
 }
 
-func ReminderFromMail(m *mail.Mail) (*Reminder, error) {
-	dueTime, content, err := parseSpec(m.Subject, m.Location)
+func (rem *Reminder) priority() string {
+	if rem.Priority == "" {
+		return PriorityNormal
+	}
+	return rem.Priority
+}
+
+func (rem *Reminder) CanEscalate() bool {
+	return rem.EscalateTo != ""
+}
+
+func (rem *Reminder) Escalation() *Reminder {
+	esc := *rem
+	esc.Recipient = rem.EscalateTo
+	esc.Recipients = nil
+	esc.IsEscalated = true
+	return &esc
+}
+
+// AckTag identifies replies to escalating reminders as acknowledgements.
+func (rem *Reminder) AckTag() string {
+	return fmt.Sprintf("[#%s]", rem.Id)
+}
+
+func (rem *Reminder) Subject() string {
+	switch {
+	case rem.IsEscalated:
+		return "Escalated reminder: " + rem.Content + " " + rem.AckTag()
+	case rem.CanEscalate():
+		return "Reminder: " + rem.Content + " " + rem.AckTag()
+	default:
+		return "Reminder: " + rem.Content
+	}
+}
+
+func (rem *Reminder) Body() string {
+	lines := make([]string, 0)
+	if len(rem.Recipients) > 0 {
+		lines = append(lines, fmt.Sprintf("This reminder was set by %s.", rem.Recipient))
+	}
+	switch {
+	case rem.IsEscalated:
+		lines = append(lines, fmt.Sprintf(
+			"This reminder was not acknowledged within %s, so it was escalated to you.\r\n"+
+				"Reply to this e-mail to acknowledge it.",
+			rem.EscalateAfter,
+		))
+	case rem.CanEscalate():
+		lines = append(lines, fmt.Sprintf(
+			"Reply to this e-mail to acknowledge the reminder, "+
+				"otherwise it will be escalated to %s in %s.",
+			rem.EscalateTo, rem.EscalateAfter,
+		))
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// ReminderFromMail adds the addresses in To, Cc and the subject as recipients
+// if the policy allows them.
+func ReminderFromMail(m *mail.Mail, policy *RecipientPolicy, now time.Time) (*Reminder, error) {
+	dueTime, content, _, err := parseSpec(m.Subject, now.In(m.Location))
 	if err != nil {
 		return nil, err
 	}
+	content, escalateTo, escalateAfter, err := parseEscalation(content)
+	if err != nil {
+		return nil, err
+	}
+	if escalateTo != "" && !policy.AllowEscalation(m.From, escalateTo) {
+		log.Warn().Msgf("%s (id=%s): escalation to %q is not allowed", m.From, m.MessageId, escalateTo)
+		escalateTo, escalateAfter = "", 0
+	}
+	content, mentions := parseMentions(content)
+	content, tags := parseTags(content)
+	content, priority := parsePriority(content)
+	candidates := append(append(append([]string{}, m.To...), m.Cc...), mentions...)
+	recipients, denied := policy.Filter(m.From, candidates)
+	for _, address := range denied {
+		log.Warn().Msgf("%s (id=%s): recipient %q is not allowed", m.From, m.MessageId, address)
+	}
 	return &Reminder{
 		Id:            uuid.Must(uuid.NewV1()),
 		GeneratedById: m.MessageId,
 		DueTime:       dueTime,
 		Recipient:     m.From,
+		Recipients:    recipients,
 		Content:       content,
 		IsSent:        false,
+		EscalateTo:    escalateTo,
+		EscalateAfter: escalateAfter,
+		Tags:          tags,
+		Priority:      priority,
 	}, nil
 }
 
+var regexAckTag = regexp.MustCompile(`\[#([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\]`)
+
+type Command interface {
+	Apply(store ReminderStore) error
+}
+
+// Replier replies once its transaction is committed, so that rollbacks send nothing.
+type Replier interface {
+	Reply(store Store) error
+}
+
+type NewReminder struct {
+	Reminder *Reminder
+}
+
+func (c *NewReminder) Apply(store ReminderStore) error {
+	return store.Save(c.Reminder)
+}
+
+type Acknowledgement struct {
+	ReminderId uuid.UUID
+	From       string
+}
+
+func (c *Acknowledgement) Apply(store ReminderStore) error {
+	if err := store.Acknowledge(c.ReminderId, c.From); err != nil {
+		return err
+	}
+	log.Info().Msgf("reminder %q acknowledged by %q", c.ReminderId, c.From)
+	return nil
+}
+
+func CommandFromMail(m *mail.Mail, policy *RecipientPolicy) (Command, error) {
+	if match := regexAckTag.FindStringSubmatch(m.Subject); match != nil {
+		id, err := uuid.FromString(match[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Acknowledgement{ReminderId: id, From: m.From}, nil
+	}
+	if cmd, err := routeCommandFromSubject(m.From, m.Subject); cmd != nil || err != nil {
+		return cmd, err
+	}
+	rem, err := ReminderFromMail(m, policy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &NewReminder{Reminder: rem}, nil
+}
+
 type ReminderMailConverter struct {
-	Mail      <-chan *mail.Mail
-	Reminders chan<- *Reminder
-	Errors    chan<- error
-	finished  bool
+	Mail     <-chan *mail.Mail
+	Commands chan<- Command
+	Rejected chan<- *mail.Mail
+	Errors   chan<- error
+	Policy   *Policy
+	finished bool
 }
 
 func (rmc *ReminderMailConverter) RunOnce() {
@@ -64,17 +257,25 @@ func (rmc *ReminderMailConverter) RunOnce() {
 		rmc.finished = true
 		return
 	}
-	rem, err := ReminderFromMail(msg)
+	if err := rmc.Policy.Check(msg); err != nil {
+		logRejection(msg, err)
+		rmc.Rejected <- msg
+		return
+	}
+	commands, err := CommandsFromMail(msg, rmc.Policy.Recipients)
 	if err != nil {
 		rmc.Errors <- fmt.Errorf("%s (id=%s): %w", msg.From, msg.MessageId, err)
-	} else {
-		rmc.Reminders <- rem
+		return
+	}
+	for _, cmd := range commands {
+		rmc.Commands <- cmd
 	}
 	return
 }
 
 func (rmc *ReminderMailConverter) Close() {
-	close(rmc.Reminders)
+	close(rmc.Commands)
+	close(rmc.Rejected)
 	close(rmc.Errors)
 }
 
@@ -85,46 +286,62 @@ func (rmc *ReminderMailConverter) Run() {
 	}
 }
 
-func NewReminderMailConverter(mail <-chan *mail.Mail) (*ReminderMailConverter, <-chan *Reminder, <-chan error) {
-	reminders := make(chan *Reminder)
+func NewReminderMailConverter(
+	messages <-chan *mail.Mail, policy *Policy,
+) (*ReminderMailConverter, <-chan Command, <-chan *mail.Mail, <-chan error) {
+	commands := make(chan Command)
+	rejected := make(chan *mail.Mail)
 	errors := make(chan error, 1)
-	return &ReminderMailConverter{mail, reminders, errors, false}, reminders, errors
+	return &ReminderMailConverter{messages, commands, rejected, errors, policy, false}, commands, rejected, errors
 }
 
 type ReminderSaver struct {
-	Pool      *pgxpool.Pool
-	Reminders <-chan *Reminder
-	Errors    chan<- error
-	finished  bool
+	Store         Store
+	Commands      <-chan Command
+	Errors        chan<- error
+	Sender        Sender
+	Quota         *Quota
+	Confirmations *Confirmations
+	finished      bool
 }
 
-func NewReminderSaver(pool *pgxpool.Pool, reminders <-chan *Reminder) (*ReminderSaver, <-chan error) {
+func NewReminderSaver(
+	store Store, commands <-chan Command, sender Sender, quota *Quota, confirmations *Confirmations,
+) (*ReminderSaver, <-chan error) {
 	errors := make(chan error, 1)
-	return &ReminderSaver{pool, reminders, errors, false}, errors
+	return &ReminderSaver{store, commands, errors, sender, quota, confirmations, false}, errors
 }
 
 func (rs *ReminderSaver) RunOnce() {
-	rem, ok := <-rs.Reminders
+	cmd, ok := <-rs.Commands
 	if !ok {
 		rs.finished = true
 		return
 	}
-	ctx := context.Background()
-	tx, err := rs.Pool.BeginTx(ctx, pgx.TxOptions{})
+	applied := cmd
+	err := rs.Store.WithTx(context.Background(), func(store ReminderStore) error {
+		applied = cmd
+		if newRem, ok := cmd.(*NewReminder); ok {
+			var quotaErr *QuotaError
+			if err := rs.Quota.CheckNew(store, newRem.Reminder); errors.As(err, &quotaErr) {
+				applied = &Rejection{Reminder: newRem.Reminder, Err: quotaErr, Sender: rs.Sender, Quota: rs.Quota}
+			} else if err != nil {
+				return err
+			} else if rs.Confirmations != nil {
+				applied = &Confirmation{Reminder: newRem.Reminder, Confirmations: rs.Confirmations}
+			}
+		}
+		return applied.Apply(store)
+	})
 	if err != nil {
 		rs.Errors <- err
 		return
 	}
-	defer tx.Rollback(ctx)
-	dao := ReminderDAO{Tx: tx, Context: ctx}
-	if err = dao.Save(rem); err != nil {
-		rs.Errors <- err
-	} else {
-		if err := tx.Commit(ctx); err != nil {
+	if replier, ok := applied.(Replier); ok {
+		if err := replier.Reply(rs.Store); err != nil {
 			rs.Errors <- err
 		}
 	}
-	return
 }
 
 func (rs *ReminderSaver) Close() {
@@ -138,34 +355,165 @@ func (rs *ReminderSaver) Run() {
 	}
 }
 
+type Rejection struct {
+	Reminder   *Reminder
+	Err        *QuotaError
+	Sender     Sender
+	Quota      *Quota
+	deliveryId int64
+}
+
+func (c *Rejection) Apply(store ReminderStore) error {
+	to := c.Reminder.Recipient
+	log.Warn().
+		Str("event", "quota_exceeded").
+		Str("from", to).
+		Str("message_id", c.Reminder.GeneratedById).
+		Str("reason", c.Err.Error()).
+		Msg("reminder not saved")
+	if err := c.Quota.CheckSend(store, to); err != nil {
+		return err
+	}
+	var err error
+	c.deliveryId, err = store.SaveDelivery(nil, to, ChannelEmail, time.Now())
+	return err
+}
+
+func (c *Rejection) Reply(store Store) error {
+	to := c.Reminder.Recipient
+	err := c.Sender.Send(
+		to,
+		"Reminder not set: "+c.Reminder.Content,
+		fmt.Sprintf(
+			"Sorry, your reminder could not be set (%s).\r\n"+
+				"Please try again later, or cancel some of your reminders.",
+			c.Err,
+		),
+	)
+	if err != nil {
+		deleteDelivery(store, c.deliveryId)
+	}
+	return err
+}
+
 type ReminderSender struct {
-	Sender    Sender
-	Errors    chan<- error
-	Reminders <-chan *Reminder
-	finished  bool
+	Channels   map[string]Channel
+	Errors     chan<- error
+	Deliveries <-chan *Delivery
+	Store      Store
+	Quota      *Quota
+	Workers    int
+	finished   bool
 }
 
-func NewReminderSender(reminders <-chan *Reminder, sender Sender) (*ReminderSender, <-chan error) {
+func NewReminderSender(
+	deliveries <-chan *Delivery, channels map[string]Channel, store Store, quota *Quota, workers int,
+) (*ReminderSender, <-chan error) {
 	errors := make(chan error, 1)
-	return &ReminderSender{sender, errors, reminders, false}, errors
+	if workers < 1 {
+		workers = 1
+	}
+	return &ReminderSender{channels, errors, deliveries, store, quota, workers, false}, errors
 }
 
 func (rs *ReminderSender) RunOnce() {
-	rem, ok := <-rs.Reminders
+	d, ok := <-rs.Deliveries
 	if !ok {
 		rs.finished = true
 		return
 	}
-	if err := rs.Sender.Send(
-		rem.Recipient,
-		"Reminder: "+rem.Content,
-		"",
-	); err != nil {
-		rs.Errors <- fmt.Errorf("error sending reminder %q to %q: %w", rem.Id, rem.Recipient, err)
+	rs.deliver(d)
+}
+
+func (rs *ReminderSender) deliver(d *Delivery) {
+	if err := rs.send(d); err != nil {
+		rs.Errors <- fmt.Errorf("error sending reminder %q to %q: %w", d.Reminder.Id, d.To, err)
 	} else {
-		log.Info().Msgf("sent reminder to %q", rem.Recipient)
+		log.Info().Msgf("sent reminder to %q on %s", d.To, strings.Join(d.Channels, ","))
 	}
-	return
+}
+
+// Deliveries are recorded before sending, outside of the transaction, so that
+// concurrent workers count them. send fails only if no channel delivered.
+func (rs *ReminderSender) send(d *Delivery) error {
+	names := rs.channels(d)
+	deliveryIds := make(map[string]int64, len(names))
+	var quotaErr error
+	err := rs.Store.WithTx(context.Background(), func(store ReminderStore) error {
+		now := time.Now()
+		for _, name := range names {
+			if name == ChannelEmail {
+				var errQuota *QuotaError
+				if err := rs.Quota.CheckSend(store, d.To); errors.As(err, &errQuota) {
+					quotaErr = err
+					continue
+				} else if err != nil {
+					return err
+				}
+			}
+			id, err := store.SaveDelivery(&d.Reminder.Id, d.To, name, now)
+			if err != nil {
+				return err
+			}
+			deliveryIds[name] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(deliveryIds) == 0 {
+		return quotaErr
+	}
+	if quotaErr != nil {
+		log.Warn().Err(quotaErr).Msgf("reminder %q not e-mailed to %q", d.Reminder.Id, d.To)
+	}
+	var deliverErr error
+	failed := 0
+	for _, name := range names {
+		id, ok := deliveryIds[name]
+		if !ok {
+			continue
+		}
+		if err := rs.Channels[name].Deliver(d.Reminder, d.To); err != nil {
+			log.Warn().Err(err).Msgf("error delivering reminder %q to %q", d.Reminder.Id, d.To)
+			deliverErr = err
+			failed++
+			deleteDelivery(rs.Store, id)
+		}
+	}
+	if failed == len(deliveryIds) {
+		return deliverErr
+	}
+	return nil
+}
+
+func deleteDelivery(store Store, deliveryId int64) {
+	if err := store.WithTx(context.Background(), func(store ReminderStore) error {
+		return store.DeleteDelivery(deliveryId)
+	}); err != nil {
+		log.Warn().Err(err).Msgf("error deleting failed delivery %d", deliveryId)
+	}
+}
+
+// Channels that are not configured are replaced by e-mail.
+func (rs *ReminderSender) channels(d *Delivery) []string {
+	names := make([]string, 0, len(d.Channels))
+	seen := make(map[string]bool)
+	for _, name := range d.Channels {
+		if _, ok := rs.Channels[name]; !ok {
+			log.Warn().Msgf("channel %q of %q is not configured, sending by e-mail", name, d.To)
+			name = ChannelEmail
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, ChannelEmail)
+	}
+	return names
 }
 
 func (rs *ReminderSender) Close() {
@@ -174,52 +522,154 @@ func (rs *ReminderSender) Close() {
 
 func (rs *ReminderSender) Run() {
 	defer rs.Close()
-	for !rs.finished {
-		rs.RunOnce()
+	var wg sync.WaitGroup
+	wg.Add(rs.Workers)
+	for i := 0; i < rs.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			for d := range rs.Deliveries {
+				rs.deliver(d)
+			}
+		}()
 	}
+	wg.Wait()
 }
 
+// DueReminderQuerier uses Location for users who have no timezone set.
 type DueReminderQuerier struct {
-	Pool      *pgxpool.Pool
+	Store     Store
 	Done      <-chan bool
 	Reminders chan<- *Reminder
 	Errors    chan<- error
 	Interval  time.Duration
+	Location  *time.Location
 }
 
 func NewDueReminderQuerier(
-	interval time.Duration, pool *pgxpool.Pool, done <-chan bool,
+	interval time.Duration, store Store, loc *time.Location, done <-chan bool,
 ) (*DueReminderQuerier, <-chan *Reminder, <-chan error) {
 	reminders := make(chan *Reminder)
 	errors := make(chan error, 1)
-	return &DueReminderQuerier{pool, done, reminders, errors, interval}, reminders, errors
+	return &DueReminderQuerier{store, done, reminders, errors, interval, loc}, reminders, errors
 }
 
-func (q *DueReminderQuerier) RunOnce() {
-	ctx := context.Background()
-	tx, err := q.Pool.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		q.Errors <- err
-		return
+func (q *DueReminderQuerier) holdUntil(users UserStore, rem *Reminder, to string, now time.Time) (time.Time, error) {
+	if rem.priority() == PriorityUrgent {
+		return now, nil
 	}
-	defer tx.Rollback(ctx)
-	dao := ReminderDAO{Tx: tx, Context: ctx}
-	rems, err := dao.QueryDue(time.Now().UTC())
-	log.Info().Msgf("found %d reminders due", len(rems))
+	quiet, err := users.QuietHours(to)
 	if err != nil {
-		q.Errors <- err
-		return
+		return now, err
 	}
-	for _, rem := range rems {
-		rem.IsSent = true
-		if err := dao.Update(rem); err != nil {
+	return quiet.HoldUntil(now, loadLocation(to, quiet.Timezone, q.Location))
+}
+
+// hold returns the reminder to deliver now, or nil if every recipient is held.
+func (q *DueReminderQuerier) hold(store ReminderStore, rem *Reminder, isEscalation bool, now time.Time) *Reminder {
+	deliverTo := make([]string, 0)
+	for _, to := range rem.AllRecipients() {
+		until, err := q.holdUntil(store.Users(), rem, to, now)
+		if err == nil && until.After(now) {
+			err = store.HoldDelivery(&HeldDelivery{rem.Id, to, isEscalation, until})
+			if err == nil && !isEscalation && to == rem.Recipient {
+				// The escalation delay starts when the owner gets the reminder.
+				err = store.Hold(rem.Id, until)
+			}
+			if err == nil {
+				log.Info().Msgf("reminder %q to %q held until %s (quiet hours)", rem.Id, to, until)
+				continue
+			}
+		}
+		if err != nil {
 			q.Errors <- err
+		}
+		deliverTo = append(deliverTo, to)
+	}
+	if len(deliverTo) == 0 {
+		return nil
+	}
+	due := *rem
+	due.deliverTo = deliverTo
+	return &due
+}
+
+// release drops deliveries of reminders rescheduled, cancelled or acknowledged
+// since they were held.
+func (q *DueReminderQuerier) release(store ReminderStore, now time.Time) ([]*Reminder, error) {
+	released, err := store.ReleaseHeldDeliveries(now)
+	if err != nil {
+		return nil, err
+	}
+	reminders := make([]*Reminder, 0, len(released))
+	for _, held := range released {
+		rem, err := store.Load(held.ReminderId)
+		if err != nil {
+			q.Errors <- fmt.Errorf("error releasing reminder %q to %q: %w", held.ReminderId, held.To, err)
+			continue
+		}
+		if held.IsEscalation {
+			if !rem.IsEscalated || rem.IsAcknowledged || rem.IsCancelled {
+				continue
+			}
+			rem = rem.Escalation()
 		} else {
-			q.Reminders <- rem
+			if !rem.IsSent || rem.IsCancelled {
+				continue
+			}
+			// The reminder may have been escalated since it was held.
+			rem.IsEscalated = false
 		}
+		rem.deliverTo = []string{held.To}
+		reminders = append(reminders, rem)
 	}
-	if err := tx.Commit(ctx); err != nil {
+	return reminders, nil
+}
+
+func (q *DueReminderQuerier) RunOnce() {
+	// Emit after commit, so that a failed transaction does not deliver twice.
+	var emit []*Reminder
+	err := q.Store.WithTx(context.Background(), func(store ReminderStore) error {
+		now := time.Now().UTC()
+		var err error
+		if emit, err = q.release(store, now); err != nil {
+			return err
+		}
+		rems, err := store.QueryDue(now)
+		log.Info().Msgf("found %d reminders due", len(rems))
+		if err != nil {
+			return err
+		}
+		for _, rem := range rems {
+			rem.IsSent = true
+			if err := store.Update(rem); err != nil {
+				q.Errors <- err
+			} else if rem := q.hold(store, rem, false, now); rem != nil {
+				emit = append(emit, rem)
+			}
+		}
+		escalations, err := store.QueryEscalationDue(now)
+		if err != nil {
+			return err
+		}
+		if len(escalations) > 0 {
+			log.Info().Msgf("found %d unacknowledged reminders to escalate", len(escalations))
+		}
+		for _, rem := range escalations {
+			rem.IsEscalated = true
+			if err := store.Update(rem); err != nil {
+				q.Errors <- err
+			} else if esc := q.hold(store, rem.Escalation(), true, now); esc != nil {
+				emit = append(emit, esc)
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		q.Errors <- err
+		return
+	}
+	for _, rem := range emit {
+		q.Reminders <- rem
 	}
 }
 
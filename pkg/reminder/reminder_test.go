@@ -1,8 +1,14 @@
 package reminder
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/mail"
 )
 
 func TestParseSpec(t *testing.T) {
@@ -10,7 +16,456 @@ func TestParseSpec(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	time, content, _ := parseSpec("12/24 12:41 buy goobers", loc)
-	t.Log(time, content)
+	now := time.Date(2023, 4, 5, 10, 0, 0, 0, loc)
+	cases := []struct {
+		subject string
+		format  string
+		due     time.Time
+	}{
+		{"12/24 12:41 buy goobers", "MM/DD HH:MM", time.Date(2023, 12, 24, 12, 41, 0, 0, loc)},
+		{"tomorrow 08:00 buy goobers", "tomorrow HH:MM", time.Date(2023, 4, 6, 8, 0, 0, 0, loc)},
+		{"2024-01-02 09:30 buy goobers", "YYYY-MM-DD HH:MM", time.Date(2024, 1, 2, 9, 30, 0, 0, loc)},
+		{"15:04 buy goobers", "HH:MM", time.Date(2023, 4, 5, 15, 4, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		due, content, format, err := parseSpec(c.subject, now)
+		if err != nil {
+			t.Fatalf("%q: %s", c.subject, err)
+		}
+		if !due.Equal(c.due) || content != "buy goobers" || format != c.format {
+			t.Errorf("%q: got %s %q %q", c.subject, due, content, format)
+		}
+	}
+	if _, _, _, err := parseSpec("buy goobers", now); err == nil {
+		t.Error("expected an error for a subject without a time")
+	}
+}
+
+func TestParseEscalation(t *testing.T) {
+	content, address, delay, err := parseEscalation("check backups escalate:lead@example.com after 30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "check backups" || address != "lead@example.com" || delay != 30*time.Minute {
+		t.Errorf("got %q %q %s", content, address, delay)
+	}
+
+	content, address, _, err = parseEscalation("check backups")
+	if err != nil || content != "check backups" || address != "" {
+		t.Errorf("got %q %q %v", content, address, err)
+	}
+
+	if _, _, _, err := parseEscalation("check backups escalate:lead@example.com after soon"); err == nil {
+		t.Error("expected error for invalid delay")
+	}
+}
+
+func TestRecipientPolicy(t *testing.T) {
+	content, mentions := parseMentions("call the plumber @bob@example.com")
+	if content != "call the plumber" || len(mentions) != 1 || mentions[0] != "bob@example.com" {
+		t.Errorf("got %q %q", content, mentions)
+	}
+
+	allow, err := NewAddressList([]string{"@example.com", "carol@other.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &RecipientPolicy{Mailbox: "reminders@example.com", Allow: allow}
+	allowed, denied := policy.Filter("alice@example.com", []string{
+		"reminders@example.com", "Bob@example.com", "alice@example.com",
+		"carol@other.com", "mallory@evil.com", "bob@example.com",
+	})
+	if len(allowed) != 2 || allowed[0] != "Bob@example.com" || allowed[1] != "carol@other.com" {
+		t.Errorf("allowed %q", allowed)
+	}
+	if len(denied) != 1 || denied[0] != "mallory@evil.com" {
+		t.Errorf("denied %q", denied)
+	}
+
+	for address, want := range map[string]bool{
+		"lead@example.com":      true,
+		"Alice@example.com":     true,
+		"reminders@example.com": false,
+		"mallory@evil.com":      false,
+	} {
+		if got := policy.AllowEscalation("alice@example.com", address); got != want {
+			t.Errorf("escalation to %q: got %t", address, got)
+		}
+	}
+	now := time.Date(2023, 4, 5, 10, 0, 0, 0, time.UTC)
+	m := &mail.Mail{From: "alice@example.com", Subject: "12:00 deploy escalate:mallory@evil.com after 30m", Location: time.UTC}
+	rem, err := ReminderFromMail(m, policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rem.CanEscalate() || rem.EscalateAfter != 0 || rem.Content != "deploy" {
+		t.Errorf("escalation to a denied address was kept: %+v", rem)
+	}
+	m.Subject = "12:00 deploy escalate:lead@example.com after 30m"
+	if rem, err := ReminderFromMail(m, policy, now); err != nil || rem.EscalateTo != "lead@example.com" {
+		t.Errorf("got %v %v", rem, err)
+	}
+}
+
+func TestSenderPolicy(t *testing.T) {
+	allow, err := NewAddressList([]string{"@example.com", `/.*@(dev|ops)\.example\.org/`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := NewAddressList([]string{"mallory@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &SenderPolicy{Allow: allow, Deny: deny}
+	for sender, allowed := range map[string]bool{
+		"alice@example.com":     true,
+		"Bob@Ops.Example.org":   true,
+		"mallory@example.com":   false,
+		"eve@example.org":       false,
+		"eve@ops.example.org.x": false,
+	} {
+		if err := policy.Check(sender); (err == nil) != allowed {
+			t.Errorf("%s: got %v", sender, err)
+		}
+	}
+
+	if _, err := NewAddressList([]string{"/[/"}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestParseTagsAndPriority(t *testing.T) {
+	content, tags := parseTags("deploy the release #Work #ops-team")
+	content, priority := parsePriority(content + " !urgent")
+	if content != "deploy the release" || len(tags) != 2 || tags[0] != "work" || tags[1] != "ops-team" {
+		t.Errorf("got %q %q", content, tags)
+	}
+	if priority != PriorityUrgent {
+		t.Errorf("got priority %q", priority)
+	}
+	if _, priority := parsePriority("water the plants!"); priority != PriorityNormal {
+		t.Errorf("got priority %q", priority)
+	}
+}
+
+func TestSelectRoute(t *testing.T) {
+	routes := []*Route{
+		{Match: MatchAny, Channels: []string{ChannelDigest}},
+		{Match: "!urgent", Channels: []string{"email", "phone"}},
+		{Match: "#work", Channels: []string{"team"}},
+	}
+	cases := []struct {
+		rem      *Reminder
+		expected string
+	}{
+		{&Reminder{Tags: []string{"home", "work"}, Priority: PriorityUrgent}, "#work"},
+		{&Reminder{Tags: []string{"home"}, Priority: PriorityUrgent}, "!urgent"},
+		{&Reminder{}, MatchAny},
+	}
+	for _, c := range cases {
+		if route := SelectRoute(routes, c.rem); route == nil || route.Match != c.expected {
+			t.Errorf("%v: got %v, expected %q", c.rem.Tags, route, c.expected)
+		}
+	}
+	if route := SelectRoute(routes[1:], &Reminder{}); route != nil {
+		t.Errorf("expected no route, got %v", route)
+	}
+
+	cmd, err := routeCommandFromSubject("Alice@example.com", "route !URGENT to email, phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, ok := cmd.(*SetRoute)
+	if !ok || set.Route.Email != "alice@example.com" || set.Route.Match != "!urgent" || len(set.Route.Channels) != 2 {
+		t.Errorf("got %#v", cmd)
+	}
+	if _, err := routeCommandFromSubject("alice@example.com", "route !someday to email"); err == nil {
+		t.Error("expected error for invalid match")
+	}
+}
+
+// routeStore is a Store with the routes and digest modes of users, that
+// records the reminders put back for digests.
+type routeStore struct {
+	ReminderStore
+	UserStore
+	routes  map[string][]*Route
+	digests map[string]string
+	awaited []uuid.UUID
+}
+
+func (s *routeStore) WithTx(ctx context.Context, f func(store ReminderStore) error) error {
+	return f(s)
+}
+
+func (s *routeStore) Close() {}
+
+func (s *routeStore) Users() UserStore { return s }
+
+func (s *routeStore) Routes(email string) ([]*Route, error) { return s.routes[email], nil }
+
+func (s *routeStore) Channel(email string) (string, error) { return ChannelEmail, nil }
 
+func (s *routeStore) Digest(email string) (string, error) {
+	if digest, ok := s.digests[email]; ok {
+		return digest, nil
+	}
+	return DigestNone, nil
+}
+
+func (s *routeStore) AwaitDigest(id uuid.UUID) error {
+	s.awaited = append(s.awaited, id)
+	return nil
+}
+
+func TestRouterDigest(t *testing.T) {
+	digest := []*Route{{Match: MatchAny, Channels: []string{ChannelDigest}}}
+	store := &routeStore{
+		routes: map[string][]*Route{
+			"alice@example.com": digest,
+			"bob@example.com":   digest,
+			"carol@example.com": {{Match: MatchAny, Channels: []string{ChannelDigest, ChannelWebhook}}},
+		},
+		digests: map[string]string{"alice@example.com": DigestDaily, "carol@example.com": DigestDaily},
+	}
+	route := func(rem *Reminder) []*Delivery {
+		reminders := make(chan *Reminder, 1)
+		router, deliveries, errs := NewReminderRouter(reminders, store)
+		reminders <- rem
+		close(reminders)
+		go func() {
+			for !router.finished {
+				router.RunOnce()
+			}
+			close(router.Deliveries)
+		}()
+		result := make([]*Delivery, 0)
+		for d := range deliveries {
+			result = append(result, d)
+		}
+		select {
+		case err := <-errs:
+			t.Error(err)
+		default:
+		}
+		return result
+	}
+
+	rem := &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "alice@example.com"}
+	if deliveries := route(rem); len(deliveries) != 0 || len(store.awaited) != 1 || store.awaited[0] != rem.Id {
+		t.Errorf("got %d deliveries, %v awaiting digest; expected the reminder to await the digest", len(deliveries), store.awaited)
+	}
+
+	// Digests do not list reminders with other recipients, and only the owner
+	// has a digest, so the recipients fall back to e-mail.
+	store.awaited = nil
+	rem = &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "alice@example.com", Recipients: []string{"bob@example.com"}}
+	deliveries := route(rem)
+	if len(deliveries) != 2 || len(store.awaited) != 0 {
+		t.Fatalf("got %d deliveries, %v awaiting digest", len(deliveries), store.awaited)
+	}
+	for _, d := range deliveries {
+		if strings.Join(d.Channels, " ") != ChannelEmail {
+			t.Errorf("%s: got channels %v", d.To, d.Channels)
+		}
+	}
+
+	// Other channels of the route are delivered when the digest cannot be used.
+	rem = &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "carol@example.com", EscalateTo: "boss@example.com"}
+	if deliveries := route(rem); len(deliveries) != 1 || strings.Join(deliveries[0].Channels, " ") != ChannelWebhook {
+		t.Errorf("got deliveries %+v", deliveries)
+	}
+
+	// Users not in digest mode get e-mail.
+	rem = &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "bob@example.com"}
+	if deliveries := route(rem); len(deliveries) != 1 || strings.Join(deliveries[0].Channels, " ") != ChannelEmail {
+		t.Errorf("got deliveries %+v", deliveries)
+	}
+	if len(store.awaited) != 0 {
+		t.Errorf("got %v awaiting digest", store.awaited)
+	}
+}
+
+func TestDigestSchedule(t *testing.T) {
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily := &DigestSettings{Mode: DigestDaily, Time: "08:00"}
+	// The day DST starts is 23 hours long; the next digest is still at 08:00.
+	now := time.Date(2023, 3, 12, 7, 0, 0, 0, loc)
+	start, end, err := daily.Schedule(now, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2023, 3, 11, 8, 0, 0, 0, loc)) || !end.Equal(time.Date(2023, 3, 12, 8, 0, 0, 0, loc)) {
+		t.Errorf("daily: got %s - %s", start, end)
+	}
+
+	weekly := &DigestSettings{Mode: DigestWeekly, Time: "08:00", Weekday: time.Monday}
+	now = time.Date(2023, 4, 5, 12, 0, 0, 0, loc) // Wednesday
+	start, end, _ = weekly.Schedule(now, loc)
+	if !start.Equal(time.Date(2023, 4, 3, 8, 0, 0, 0, loc)) || !end.Equal(time.Date(2023, 4, 10, 8, 0, 0, 0, loc)) {
+		t.Errorf("weekly: got %s - %s", start, end)
+	}
+}
+
+func TestAgenda(t *testing.T) {
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 4, 3, 8, 0, 0, 0, loc)
+	reminders := []*Reminder{
+		{DueTime: time.Date(2023, 4, 3, 13, 30, 0, 0, time.UTC), Content: "call the plumber"},
+		{DueTime: time.Date(2023, 4, 4, 14, 0, 0, 0, time.UTC), Content: "deploy", Tags: []string{"work"}, Priority: PriorityHigh},
+	}
+	subject, body, err := Agenda(DigestWeekly, start, reminders, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Agenda for the week of April 3" {
+		t.Errorf("got subject %q", subject)
+	}
+	expected := "Monday, April 3\r\n  09:30  call the plumber\r\n\r\nTuesday, April 4\r\n  10:00  deploy !high #work"
+	if body != expected {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet := &QuietHours{Start: "22:00", End: "07:00", Weekends: true}
+	cases := []struct {
+		due      time.Time
+		expected time.Time
+	}{
+		// Outside of quiet hours
+		{time.Date(2023, 4, 5, 12, 0, 0, 0, loc), time.Date(2023, 4, 5, 12, 0, 0, 0, loc)},
+		// Before and after midnight
+		{time.Date(2023, 4, 5, 23, 0, 0, 0, loc), time.Date(2023, 4, 6, 7, 0, 0, 0, loc)},
+		{time.Date(2023, 4, 6, 3, 0, 0, 0, loc), time.Date(2023, 4, 6, 7, 0, 0, 0, loc)},
+		// Friday night, then the weekend, then Monday morning
+		{time.Date(2023, 4, 7, 23, 0, 0, 0, loc), time.Date(2023, 4, 10, 7, 0, 0, 0, loc)},
+		// The night DST starts
+		{time.Date(2023, 3, 9, 23, 0, 0, 0, loc), time.Date(2023, 3, 10, 7, 0, 0, 0, loc)},
+		{time.Date(2023, 3, 12, 1, 30, 0, 0, loc), time.Date(2023, 3, 13, 7, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		until, err := quiet.HoldUntil(c.due.UTC(), loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !until.Equal(c.expected) {
+			t.Errorf("%s: got %s, expected %s", c.due, until, c.expected)
+		}
+	}
+	// DST starts on Sunday March 12; 07:00 is EDT.
+	weeknights := &QuietHours{Start: "22:00", End: "07:00"}
+	until, _ := weeknights.HoldUntil(time.Date(2023, 3, 11, 23, 0, 0, 0, loc), loc)
+	if !until.Equal(time.Date(2023, 3, 12, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("DST: got %s", until)
+	}
+}
+
+func TestActionLinks(t *testing.T) {
+	links := &ActionLinks{BaseURL: "https://remind.example.com", Key: []byte("key")}
+	now := time.Date(2023, 4, 5, 12, 0, 0, 0, time.UTC)
+	expected := &ActionToken{
+		Action:     ActionSnooze,
+		ReminderId: uuid.Must(uuid.NewV4()),
+		Recipient:  "Alice@example.com",
+		Expires:    now.Add(ActionLinkLifetime),
+	}
+	token := links.Sign(expected)
+	got, err := links.Verify(token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Action != expected.Action || got.ReminderId != expected.ReminderId ||
+		got.Recipient != "alice@example.com" || !got.Expires.Equal(expected.Expires) {
+		t.Errorf("got %+v", got)
+	}
+	if _, err := links.Verify(token, expected.Expires); err != ErrExpiredActionToken {
+		t.Errorf("expected expired token, got %v", err)
+	}
+	other := &ActionLinks{Key: []byte("other key")}
+	if _, err := other.Verify(token, now); err != ErrInvalidActionToken {
+		t.Errorf("expected invalid signature, got %v", err)
+	}
+	expected.Action = ActionCancel
+	payload, _, _ := strings.Cut(links.Sign(expected), ".")
+	_, mac, _ := strings.Cut(token, ".")
+	if _, err := links.Verify(payload+"."+mac, now); err != ErrInvalidActionToken {
+		t.Errorf("expected invalid tampered token, got %v", err)
+	}
+
+	rem := &Reminder{Id: expected.ReminderId, Recipient: "alice@example.com"}
+	body := links.Body(rem, "alice@example.com", now)
+	if lines := strings.Split(body, "\r\n"); len(lines) != len(Actions) ||
+		!strings.HasPrefix(lines[0], "Done: https://remind.example.com/actions/") {
+		t.Errorf("unexpected links:\n%s", body)
+	}
+}
+
+func TestActionUntil(t *testing.T) {
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 22:30 UTC is 18:30 in Montreal, so tomorrow is still April 6.
+	now := time.Date(2023, 4, 5, 22, 30, 0, 0, time.UTC)
+	if until := ActionUntil(ActionSnooze, now, loc); !until.Equal(now.Add(time.Hour)) {
+		t.Errorf("snooze: got %s", until)
+	}
+	if until := ActionUntil(ActionTomorrow, now, loc); !until.Equal(time.Date(2023, 4, 6, 9, 0, 0, 0, loc)) {
+		t.Errorf("tomorrow: got %s", until)
+	}
+}
+
+func TestRemindersFromInvite(t *testing.T) {
+	allow, err := NewAddressList([]string{"@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &RecipientPolicy{Mailbox: "reminders@example.com", Allow: allow}
+	now := time.Date(2023, 4, 5, 12, 0, 0, 0, time.UTC)
+	start := time.Date(2023, 4, 6, 15, 0, 0, 0, time.UTC)
+	m := &mail.Mail{
+		MessageId: "<invite@example.com>",
+		From:      "alice@example.com",
+		To:        []string{"reminders@example.com"},
+		Cc:        []string{"bob@example.com"},
+		Subject:   "Fwd: Invitation: Sync",
+		Location:  time.UTC,
+		Events: []*ical.Event{
+			{Summary: "Sync", Start: start, Alarms: []time.Time{}},
+			{Summary: "Review", Start: start, Alarms: []time.Time{
+				now.Add(-time.Hour), start.Add(-time.Hour), start.Add(-time.Hour),
+			}},
+			{Summary: "Cancelled", Start: start, Method: ical.MethodCancel},
+		},
+	}
+	reminders, err := RemindersFromInvite(m, policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 2 {
+		t.Fatalf("got %d reminders", len(reminders))
+	}
+	if rem := reminders[0]; rem.Content != "Sync (Thu 6 Apr 15:00)" || !rem.DueTime.Equal(start.Add(-InviteLead)) ||
+		rem.Recipient != "alice@example.com" || len(rem.Recipients) != 1 || rem.Recipients[0] != "bob@example.com" {
+		t.Errorf("got %+v", rem)
+	}
+	if rem := reminders[1]; rem.Content != "Review (Thu 6 Apr 15:00)" || !rem.DueTime.Equal(start.Add(-time.Hour)) {
+		t.Errorf("got %+v", rem)
+	}
+
+	m.Events = m.Events[2:]
+	if _, err := RemindersFromInvite(m, policy, now); err == nil {
+		t.Error("expected error for an invite without upcoming events")
+	}
 }
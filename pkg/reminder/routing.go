@@ -0,0 +1,236 @@
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const MatchAny = "*"
+
+// Match is either a tag ("#work"), a priority ("!urgent") or MatchAny.
+type Route struct {
+	Email    string
+	Match    string
+	Channels []string
+}
+
+var regexRouteMatch = regexp.MustCompile(`^(\*|#[\w-]+|!(low|normal|high|urgent))$`)
+
+func ParseRouteMatch(s string) (string, error) {
+	match := strings.ToLower(strings.TrimSpace(s))
+	if !regexRouteMatch.MatchString(match) {
+		return "", fmt.Errorf("invalid route match %q, expected #tag, !priority or *", s)
+	}
+	return match, nil
+}
+
+func ParseRouteChannels(s string) ([]string, error) {
+	channels := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels in %q", s)
+	}
+	return channels, nil
+}
+
+// Tag routes have precedence over priority routes, then MatchAny routes.
+func SelectRoute(routes []*Route, rem *Reminder) *Route {
+	byMatch := make(map[string]*Route, len(routes))
+	for _, route := range routes {
+		byMatch[route.Match] = route
+	}
+	for _, tag := range rem.Tags {
+		if route, ok := byMatch["#"+tag]; ok {
+			return route
+		}
+	}
+	if route, ok := byMatch["!"+rem.priority()]; ok {
+		return route
+	}
+	return byMatch[MatchAny]
+}
+
+type Delivery struct {
+	Reminder *Reminder
+	To       string
+	Channels []string
+}
+
+// Recipients with no matching route get reminders on users.channel.
+type ReminderRouter struct {
+	Store      Store
+	Reminders  <-chan *Reminder
+	Deliveries chan<- *Delivery
+	Errors     chan<- error
+	finished   bool
+}
+
+func NewReminderRouter(
+	reminders <-chan *Reminder, store Store,
+) (*ReminderRouter, <-chan *Delivery, <-chan error) {
+	deliveries := make(chan *Delivery)
+	errors := make(chan error, 1)
+	return &ReminderRouter{store, reminders, deliveries, errors, false}, deliveries, errors
+}
+
+func (rr *ReminderRouter) RunOnce() {
+	rem, ok := <-rr.Reminders
+	if !ok {
+		rr.finished = true
+		return
+	}
+	for _, to := range rem.deliveryRecipients() {
+		channels, err := rr.route(rem, to)
+		if err != nil {
+			rr.Errors <- fmt.Errorf("error routing reminder %q to %q: %w", rem.Id, to, err)
+			channels = []string{ChannelEmail}
+		}
+		if len(channels) == 0 {
+			log.Info().Msgf("reminder %q left for the digest of %q", rem.Id, to)
+			continue
+		}
+		rr.Deliveries <- &Delivery{rem, to, channels}
+	}
+}
+
+func (rr *ReminderRouter) route(rem *Reminder, to string) ([]string, error) {
+	var channels []string
+	err := rr.Store.WithTx(context.Background(), func(store ReminderStore) error {
+		users := store.Users()
+		routes, err := users.Routes(to)
+		if err != nil {
+			return err
+		}
+		if route := SelectRoute(routes, rem); route != nil {
+			channels = route.Channels
+		} else {
+			channel, err := users.Channel(to)
+			if err != nil {
+				return err
+			}
+			channels = []string{channel}
+		}
+		if !contains(channels, ChannelDigest) {
+			return nil
+		}
+		channels = remove(channels, ChannelDigest)
+		if digested, err := awaitDigest(store, rem, to); err != nil || digested {
+			return err
+		}
+		log.Warn().Msgf("reminder %q cannot be listed in a digest of %q", rem.Id, to)
+		if len(channels) == 0 {
+			channels = []string{ChannelEmail}
+		}
+		return nil
+	})
+	return channels, err
+}
+
+// Digests only list reminders with no escalation and no other recipients.
+func awaitDigest(store ReminderStore, rem *Reminder, to string) (bool, error) {
+	if to != rem.Recipient || rem.IsEscalated || rem.CanEscalate() || len(rem.Recipients) > 0 {
+		return false, nil
+	}
+	digest, err := store.Users().Digest(to)
+	if err != nil || digest == DigestNone {
+		return false, err
+	}
+	return true, store.AwaitDigest(rem.Id)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (rr *ReminderRouter) Close() {
+	close(rr.Deliveries)
+	close(rr.Errors)
+}
+
+func (rr *ReminderRouter) Run() {
+	defer rr.Close()
+	for !rr.finished {
+		rr.RunOnce()
+	}
+}
+
+type SetRoute struct {
+	Route *Route
+}
+
+func (c *SetRoute) Apply(store ReminderStore) error {
+	dao, err := postgres(store)
+	if err != nil {
+		return err
+	}
+	users := UserDAO{Tx: dao.Tx, Context: dao.Context}
+	if err := users.SaveRoute(c.Route); err != nil {
+		return err
+	}
+	log.Info().Msgf("route %q of %q set to %s", c.Route.Match, c.Route.Email, strings.Join(c.Route.Channels, ","))
+	return nil
+}
+
+type DeleteRoute struct {
+	Email string
+	Match string
+}
+
+func (c *DeleteRoute) Apply(store ReminderStore) error {
+	dao, err := postgres(store)
+	if err != nil {
+		return err
+	}
+	users := UserDAO{Tx: dao.Tx, Context: dao.Context}
+	if err := users.DeleteRoute(c.Email, c.Match); err != nil {
+		return err
+	}
+	log.Info().Msgf("route %q of %q deleted", c.Match, c.Email)
+	return nil
+}
+
+var regexRouteCommand = regexp.MustCompile(`(?i)^\s*route\s+(\S+)\s+to\s+(.+?)\s*$`)
+var regexUnrouteCommand = regexp.MustCompile(`(?i)^\s*unroute\s+(\S+)\s*$`)
+
+// routeCommandFromSubject returns nil if the subject is not a route command.
+func routeCommandFromSubject(from string, subject string) (Command, error) {
+	if m := regexRouteCommand.FindStringSubmatch(subject); m != nil {
+		match, err := ParseRouteMatch(m[1])
+		if err != nil {
+			return nil, err
+		}
+		channels, err := ParseRouteChannels(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return &SetRoute{&Route{Email: strings.ToLower(from), Match: match, Channels: channels}}, nil
+	}
+	if m := regexUnrouteCommand.FindStringSubmatch(subject); m != nil {
+		match, err := ParseRouteMatch(m[1])
+		if err != nil {
+			return nil, err
+		}
+		return &DeleteRoute{Email: from, Match: match}, nil
+	}
+	return nil, nil
+}
@@ -6,10 +6,9 @@ import (
 	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jbchouinard/mxremind/pkg/config"
-	"github.com/jbchouinard/mxremind/pkg/db"
 	"github.com/jbchouinard/mxremind/pkg/mail"
+	"github.com/rs/zerolog/log"
 )
 
 type Component interface {
@@ -33,18 +32,23 @@ func (err *ComponentError) Unwrap() error {
 
 type Service struct {
 	conf      *config.Config
-	dbpool    *pgxpool.Pool
+	store     Store
+	smtpPool  *mail.SmtpPool
 	fetcher   Component
 	converter Component
+	rejecter  Component
 	saver     Component
 	querier   Component
+	digest    Component
+	canceller Component
+	router    Component
 	sender    Component
 	dones     []chan<- bool
 	errors    chan error
 }
 
 func NewService(ctx context.Context, conf *config.Config) (*Service, error) {
-	dbpool, err := db.NewPool(ctx, conf.Database.URL)
+	store, err := OpenStore(ctx, conf.Database.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -54,22 +58,69 @@ func NewService(ctx context.Context, conf *config.Config) (*Service, error) {
 	// Receive and save new reminders
 	fetchDone := make(chan bool)
 	fetcher, messages, fetcherErrors := mail.NewMailFetcher(conf, 10, fetchDone)
-	converter, reminders, converterErrors := NewReminderMailConverter(messages)
-	saver, saverErrors := NewReminderSaver(dbpool, reminders)
+	policy, err := NewPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	converter, commands, rejected, converterErrors := NewReminderMailConverter(messages, policy)
+	rejecter, rejecterErrors := mail.NewMailRejecter(conf, rejected)
+	var smtpSender Sender = &mail.SmtpSender{Conf: conf.SMTP}
+	var smtpPool *mail.SmtpPool
+	if conf.SMTP.Pool.Size > 0 {
+		smtpPool = mail.NewSmtpPool(conf.SMTP)
+		smtpSender = smtpPool
+	}
+	quota := NewQuota(conf.Limits)
+	pg, isPostgres := store.(*PostgresStore)
+	var confirmations *Confirmations
+	if isPostgres {
+		confirmations = NewConfirmations(conf, smtpSender, quota)
+	} else if conf.Confirm {
+		log.Warn().Msg("confirm requires PostgreSQL, reminders will not be confirmed")
+	}
+	saver, saverErrors := NewReminderSaver(store, commands, smtpSender, quota, confirmations)
 
 	// Query and send due reminders
 	queryDone := make(chan bool)
 	querier, dueReminders, querierErrors := NewDueReminderQuerier(
-		time.Duration(conf.SendInterval)*time.Second, dbpool, queryDone,
+		time.Duration(conf.SendInterval)*time.Second, store, conf.Location(), queryDone,
 	)
-	sender, senderErrors := NewReminderSender(dueReminders, &mail.SmtpSender{Conf: conf.SMTP})
+	channels, err := NewChannels(conf, smtpSender)
+	if err != nil {
+		return nil, err
+	}
+	digestDone := make(chan bool)
+	cancelDone := make(chan bool)
+	var digest, canceller Component
+	var digestErrors, cancellerErrors <-chan error
+	if isPostgres {
+		digest, digestErrors = NewDigestScheduler(
+			time.Duration(conf.SendInterval)*time.Second, pg.Pool, smtpSender, quota, conf.Location(), digestDone,
+		)
+		canceller, cancellerErrors = NewInviteCanceller(
+			time.Duration(conf.SendInterval)*time.Second, pg.Pool, smtpSender, quota, NewInvites(conf), cancelDone,
+		)
+	} else {
+		// Only PostgreSQL stores user settings.
+		if conf.Calendar.Attach {
+			log.Warn().Msg("cancelling calendar events requires PostgreSQL, cancelled reminders will stay in calendars")
+		}
+		digest, digestErrors = newDisabledComponent(digestDone)
+		canceller, cancellerErrors = newDisabledComponent(cancelDone)
+	}
+	router, deliveries, routerErrors := NewReminderRouter(dueReminders, store)
+	sender, senderErrors := NewReminderSender(deliveries, channels, store, quota, conf.SMTP.Pool.Size)
 
 	var wg sync.WaitGroup
-	wg.Add(5)
+	wg.Add(9)
 	go errorPipe("fetcher", fetcherErrors, errors, &wg)
 	go errorPipe("converter", converterErrors, errors, &wg)
+	go errorPipe("rejecter", rejecterErrors, errors, &wg)
 	go errorPipe("saver", saverErrors, errors, &wg)
 	go errorPipe("querier", querierErrors, errors, &wg)
+	go errorPipe("digest", digestErrors, errors, &wg)
+	go errorPipe("canceller", cancellerErrors, errors, &wg)
+	go errorPipe("router", routerErrors, errors, &wg)
 	go errorPipe("sender", senderErrors, errors, &wg)
 	go func(wg *sync.WaitGroup) {
 		defer close(errors)
@@ -78,13 +129,18 @@ func NewService(ctx context.Context, conf *config.Config) (*Service, error) {
 
 	return &Service{
 		conf:      conf,
-		dbpool:    dbpool,
+		store:     store,
+		smtpPool:  smtpPool,
 		saver:     saver,
 		querier:   querier,
+		digest:    digest,
+		canceller: canceller,
+		router:    router,
 		sender:    sender,
 		fetcher:   fetcher,
 		converter: converter,
-		dones:     []chan<- bool{fetchDone, queryDone},
+		rejecter:  rejecter,
+		dones:     []chan<- bool{fetchDone, queryDone, digestDone, cancelDone},
 		errors:    errors,
 	}, nil
 }
@@ -95,9 +151,13 @@ func (s *Service) Errors() <-chan error {
 
 func (s *Service) Start() {
 	go s.querier.Run()
+	go s.digest.Run()
+	go s.canceller.Run()
+	go s.router.Run()
 	go s.sender.Run()
 	go s.fetcher.Run()
 	go s.converter.Run()
+	go s.rejecter.Run()
 	go s.saver.Run()
 }
 
@@ -121,10 +181,16 @@ func (s *Service) Drain() []error {
 func (s *Service) RunOnce() {
 	go func() {
 		go s.converter.Run()
+		go s.rejecter.Run()
 		go s.saver.Run()
+		go s.router.Run()
 		go s.sender.Run()
 		s.fetcher.RunOnce()
 		s.fetcher.Close()
+		s.digest.RunOnce()
+		s.digest.Close()
+		s.canceller.RunOnce()
+		s.canceller.Close()
 		s.querier.RunOnce()
 		s.querier.Close()
 	}()
@@ -134,7 +200,10 @@ func (s *Service) Close() {
 	for _, c := range s.dones {
 		defer close(c)
 	}
-	defer s.dbpool.Close()
+	defer s.store.Close()
+	if s.smtpPool != nil {
+		defer s.smtpPool.Close()
+	}
 }
 
 func errorPipe(name string, from <-chan error, to chan<- error, wg *sync.WaitGroup) {
@@ -147,3 +216,25 @@ func errorPipe(name string, from <-chan error, to chan<- error, wg *sync.WaitGro
 		to <- &ComponentError{Name: name, Err: err}
 	}
 }
+
+// disabledComponent stands in for components the storage backend lacks.
+type disabledComponent struct {
+	Done   <-chan bool
+	Errors chan error
+}
+
+func newDisabledComponent(done <-chan bool) (*disabledComponent, <-chan error) {
+	errors := make(chan error)
+	return &disabledComponent{done, errors}, errors
+}
+
+func (dc *disabledComponent) RunOnce() {}
+
+func (dc *disabledComponent) Close() {
+	close(dc.Errors)
+}
+
+func (dc *disabledComponent) Run() {
+	defer dc.Close()
+	<-dc.Done
+}
@@ -0,0 +1,394 @@
+package reminder
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// SQLiteStore only supports the mail pipeline; users have the default settings.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+func (s *SQLiteStore) WithTx(ctx context.Context, f func(store ReminderStore) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(&sqliteTx{tx, ctx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Close() {
+	s.DB.Close()
+}
+
+// Times are stored as Unix timestamps, and tags separated by spaces.
+type sqliteTx struct {
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+const sqliteReminderColumns = `id, generated_from_id, recipient, content, due_time, is_sent,
+	escalate_to, escalate_after, is_acknowledged, is_escalated, tags, priority,
+	is_digested, is_cancelled`
+
+const sqliteSelectReminderColumns = sqliteReminderColumns + `,
+	(SELECT group_concat(recipient, ' ') FROM (
+		SELECT rr.recipient FROM reminder_recipients rr
+		WHERE rr.reminder_id = reminders.id ORDER BY rr.recipient))`
+
+func (s *sqliteTx) scan(row interface{ Scan(...any) error }) (*Reminder, error) {
+	var rem Reminder
+	var id, tags string
+	var dueTime int64
+	var recipients sql.NullString
+	err := row.Scan(
+		&id,
+		&rem.GeneratedById,
+		&rem.Recipient,
+		&rem.Content,
+		&dueTime,
+		&rem.IsSent,
+		&rem.EscalateTo,
+		&rem.EscalateAfter,
+		&rem.IsAcknowledged,
+		&rem.IsEscalated,
+		&tags,
+		&rem.Priority,
+		&rem.IsDigested,
+		&rem.IsCancelled,
+		&recipients,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rem.Id, err = uuid.FromString(id); err != nil {
+		return nil, err
+	}
+	rem.DueTime = time.Unix(dueTime, 0).UTC()
+	rem.Tags = strings.Fields(tags)
+	rem.Recipients = strings.Fields(recipients.String)
+	return &rem, nil
+}
+
+func (s *sqliteTx) query(query string, args ...any) ([]*Reminder, error) {
+	rows, err := s.tx.QueryContext(s.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reminders := make([]*Reminder, 0)
+	for rows.Next() {
+		rem, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, rem)
+	}
+	return reminders, rows.Err()
+}
+
+func (s *sqliteTx) count(query string, args ...any) (int, error) {
+	var count int
+	err := s.tx.QueryRowContext(s.ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteTx) Load(id uuid.UUID) (*Reminder, error) {
+	return s.scan(s.tx.QueryRowContext(
+		s.ctx,
+		`SELECT `+sqliteSelectReminderColumns+` FROM reminders WHERE id = $1`,
+		id.String(),
+	))
+}
+
+func (s *sqliteTx) Save(rem *Reminder) error {
+	_, err := s.tx.ExecContext(
+		s.ctx,
+		`INSERT INTO reminders
+			(`+sqliteReminderColumns+`)
+			VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		rem.Id.String(),
+		rem.GeneratedById,
+		rem.Recipient,
+		rem.Content,
+		rem.DueTime.Unix(),
+		rem.IsSent,
+		rem.EscalateTo,
+		rem.EscalateAfter,
+		rem.IsAcknowledged,
+		rem.IsEscalated,
+		strings.Join(rem.tags(), " "),
+		rem.priority(),
+		rem.IsDigested,
+		rem.IsCancelled,
+	)
+	if err != nil {
+		return err
+	}
+	return s.saveRecipients(rem)
+}
+
+func (s *sqliteTx) saveRecipients(rem *Reminder) error {
+	for _, recipient := range rem.Recipients {
+		if _, err := s.tx.ExecContext(
+			s.ctx,
+			`INSERT INTO reminder_recipients (reminder_id, recipient) VALUES ($1, $2)`,
+			rem.Id.String(),
+			recipient,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteTx) Update(rem *Reminder) error {
+	_, err := s.tx.ExecContext(
+		s.ctx,
+		`UPDATE reminders
+			SET generated_from_id = $2,
+				recipient = $3,
+				content = $4,
+				due_time = $5,
+				is_sent = $6,
+				escalate_to = $7,
+				escalate_after = $8,
+				is_acknowledged = $9,
+				is_escalated = $10,
+				tags = $11,
+				priority = $12,
+				is_digested = $13,
+				is_cancelled = $14
+			WHERE id = $1`,
+		rem.Id.String(),
+		rem.GeneratedById,
+		rem.Recipient,
+		rem.Content,
+		rem.DueTime.Unix(),
+		rem.IsSent,
+		rem.EscalateTo,
+		rem.EscalateAfter,
+		rem.IsAcknowledged,
+		rem.IsEscalated,
+		strings.Join(rem.tags(), " "),
+		rem.priority(),
+		rem.IsDigested,
+		rem.IsCancelled,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := s.tx.ExecContext(
+		s.ctx,
+		`DELETE FROM reminder_recipients WHERE reminder_id = $1`,
+		rem.Id.String(),
+	); err != nil {
+		return err
+	}
+	return s.saveRecipients(rem)
+}
+
+func (s *sqliteTx) Acknowledge(id uuid.UUID, by string) error {
+	result, err := s.tx.ExecContext(
+		s.ctx,
+		`UPDATE reminders
+			SET is_acknowledged = 1
+			WHERE id = $1
+			  AND is_sent
+			  AND (lower(recipient) = lower($2) OR lower(escalate_to) = lower($2) OR EXISTS (
+				SELECT 1 FROM reminder_recipients rr
+				WHERE rr.reminder_id = reminders.id AND lower(rr.recipient) = lower($2)))`,
+		id.String(),
+		by,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("no sent reminder %q for %q to acknowledge", id, by)
+	}
+	return nil
+}
+
+func (s *sqliteTx) Hold(id uuid.UUID, until time.Time) error {
+	_, err := s.tx.ExecContext(
+		s.ctx,
+		`UPDATE reminders SET held_until = $2 WHERE id = $1`,
+		id.String(),
+		until.Unix(),
+	)
+	return err
+}
+
+func (s *sqliteTx) HoldDelivery(held *HeldDelivery) error {
+	_, err := s.tx.ExecContext(
+		s.ctx,
+		`INSERT INTO held_deliveries (reminder_id, recipient, is_escalation, held_until)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (reminder_id, recipient, is_escalation)
+			DO UPDATE SET held_until = excluded.held_until`,
+		held.ReminderId.String(),
+		held.To,
+		held.IsEscalation,
+		held.Until.Unix(),
+	)
+	return err
+}
+
+func (s *sqliteTx) ReleaseHeldDeliveries(asOf time.Time) ([]*HeldDelivery, error) {
+	rows, err := s.tx.QueryContext(
+		s.ctx,
+		`DELETE FROM held_deliveries
+			WHERE held_until <= $1
+			RETURNING reminder_id, recipient, is_escalation, held_until`,
+		asOf.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	released := make([]*HeldDelivery, 0)
+	for rows.Next() {
+		var held HeldDelivery
+		var id string
+		var until int64
+		if err := rows.Scan(&id, &held.To, &held.IsEscalation, &until); err != nil {
+			return nil, err
+		}
+		if held.ReminderId, err = uuid.FromString(id); err != nil {
+			return nil, err
+		}
+		held.Until = time.Unix(until, 0).UTC()
+		released = append(released, &held)
+	}
+	return released, rows.Err()
+}
+
+func (s *sqliteTx) AwaitDigest(id uuid.UUID) error {
+	return fmt.Errorf("%w: digests require PostgreSQL", ErrUnsupported)
+}
+
+func (s *sqliteTx) QueryDue(asOf time.Time) ([]*Reminder, error) {
+	return s.query(
+		`SELECT `+sqliteSelectReminderColumns+`
+			FROM reminders
+			WHERE due_time <= $1
+			  AND NOT is_sent
+			  AND NOT is_cancelled
+			  AND (held_until IS NULL OR held_until <= $1)`,
+		asOf.Unix(),
+	)
+}
+
+func (s *sqliteTx) QueryEscalationDue(asOf time.Time) ([]*Reminder, error) {
+	return s.query(
+		`SELECT `+sqliteSelectReminderColumns+`
+			FROM reminders
+			WHERE is_sent
+			  AND NOT is_cancelled
+			  AND escalate_to <> ''
+			  AND NOT is_acknowledged
+			  AND NOT is_escalated
+			  AND COALESCE(held_until, due_time) + escalate_after / 1000000000 <= $1`,
+		asOf.Unix(),
+	)
+}
+
+func (s *sqliteTx) CountActive(recipient string) (int, error) {
+	return s.count(
+		`SELECT count(*) FROM reminders
+			WHERE lower(recipient) = lower($1)
+			  AND NOT is_sent
+			  AND NOT is_cancelled`,
+		recipient,
+	)
+}
+
+func (s *sqliteTx) CountCreatedSince(recipient string, since time.Time) (int, error) {
+	return s.count(
+		`SELECT count(*) FROM reminders
+			WHERE lower(recipient) = lower($1)
+			  AND created_at >= $2`,
+		recipient,
+		since.Unix(),
+	)
+}
+
+// SQLite transactions take the write lock when they begin.
+func (s *sqliteTx) LockRecipient(recipient string) error {
+	return nil
+}
+
+func (s *sqliteTx) SaveDelivery(id *uuid.UUID, recipient string, channel string, sentAt time.Time) (int64, error) {
+	var reminderId sql.NullString
+	if id != nil {
+		reminderId = sql.NullString{String: id.String(), Valid: true}
+	}
+	result, err := s.tx.ExecContext(
+		s.ctx,
+		`INSERT INTO deliveries (reminder_id, recipient, channel, sent_at) VALUES ($1, $2, $3, $4)`,
+		reminderId,
+		recipient,
+		channel,
+		sentAt.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *sqliteTx) DeleteDelivery(deliveryId int64) error {
+	_, err := s.tx.ExecContext(s.ctx, `DELETE FROM deliveries WHERE rowid = $1`, deliveryId)
+	return err
+}
+
+func (s *sqliteTx) CountDeliveriesSince(recipient string, since time.Time) (int, error) {
+	return s.count(
+		`SELECT count(*) FROM deliveries
+			WHERE lower(recipient) = lower($1)
+			  AND channel = 'email'
+			  AND sent_at >= $2`,
+		recipient,
+		since.Unix(),
+	)
+}
+
+func (s *sqliteTx) Users() UserStore {
+	return sqliteUsers{}
+}
+
+type sqliteUsers struct{}
+
+func (sqliteUsers) Limits(email string, defaults *Limits) (*Limits, error) {
+	return defaults, nil
+}
+
+func (sqliteUsers) Channel(email string) (string, error) {
+	return ChannelEmail, nil
+}
+
+func (sqliteUsers) Digest(email string) (string, error) {
+	return DigestNone, nil
+}
+
+func (sqliteUsers) Routes(email string) ([]*Route, error) {
+	return []*Route{}, nil
+}
+
+func (sqliteUsers) QuietHours(email string) (*QuietHours, error) {
+	return &QuietHours{}, nil
+}
@@ -0,0 +1,319 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/db"
+)
+
+func testSQLiteStore(t *testing.T) Store {
+	ctx := context.Background()
+	url := "sqlite://" + filepath.Join(t.TempDir(), "mxremind.db")
+	if err := db.Migrate(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenStore(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	if _, ok := store.(*SQLiteStore); !ok {
+		t.Fatalf("got %T, expected a SQLiteStore", store)
+	}
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	store := testSQLiteStore(t)
+
+	due := time.Date(2023, 4, 5, 10, 0, 0, 0, time.UTC)
+	rem := &Reminder{
+		Id:            uuid.Must(uuid.NewV4()),
+		DueTime:       due,
+		Recipient:     "joe@example.com",
+		Recipients:    []string{"bob@example.com"},
+		Content:       "buy goobers",
+		EscalateTo:    "boss@example.com",
+		EscalateAfter: time.Hour,
+		Tags:          []string{"errands", "food"},
+	}
+	err := store.WithTx(ctx, func(store ReminderStore) error {
+		if err := store.Save(rem); err != nil {
+			return err
+		}
+		if n, err := store.CountActive("Joe@example.com"); err != nil || n != 1 {
+			t.Errorf("got %d active reminders, expected 1 (%v)", n, err)
+		}
+		loaded, err := store.Load(rem.Id)
+		if err != nil {
+			return err
+		}
+		if !loaded.DueTime.Equal(due) || loaded.Content != rem.Content ||
+			len(loaded.Tags) != 2 || len(loaded.Recipients) != 1 || loaded.EscalateAfter != time.Hour {
+			t.Errorf("got %+v, expected %+v", loaded, rem)
+		}
+
+		if reminders, err := store.QueryDue(due.Add(-time.Minute)); err != nil || len(reminders) != 0 {
+			t.Errorf("got %d reminders due before the due time (%v)", len(reminders), err)
+		}
+		reminders, err := store.QueryDue(due)
+		if err != nil {
+			return err
+		}
+		if len(reminders) != 1 || reminders[0].Id != rem.Id {
+			t.Fatalf("got %d reminders due, expected 1", len(reminders))
+		}
+
+		if err := store.Acknowledge(rem.Id, "bob@example.com"); err == nil {
+			t.Error("acknowledged a reminder that was not sent")
+		}
+		rem.IsSent = true
+		rem.Recipients = []string{"carol@example.com"}
+		if err := store.Update(rem); err != nil {
+			return err
+		}
+		if loaded, err := store.Load(rem.Id); err != nil || strings.Join(loaded.Recipients, " ") != "carol@example.com" {
+			t.Errorf("got recipients %v after update (%v)", loaded.Recipients, err)
+		}
+		if reminders, err := store.QueryEscalationDue(due.Add(time.Hour)); err != nil || len(reminders) != 1 {
+			t.Errorf("got %d escalations due, expected 1 (%v)", len(reminders), err)
+		}
+		if err := store.Acknowledge(rem.Id, "Carol@Example.com"); err != nil {
+			return err
+		}
+		if reminders, err := store.QueryEscalationDue(due.Add(time.Hour)); err != nil || len(reminders) != 0 {
+			t.Errorf("got %d escalations due after acknowledgement (%v)", len(reminders), err)
+		}
+		if _, err := store.SaveDelivery(&rem.Id, rem.Recipient, ChannelEmail, due); err != nil {
+			return err
+		}
+		if n, err := store.CountDeliveriesSince(rem.Recipient, due); err != nil || n != 1 {
+			t.Errorf("got %d deliveries, expected 1 (%v)", n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storeChannel counts deliveries. It opens a transaction to deliver, which
+// times out if the sender delivers in a transaction, as SQLite stores have a
+// single connection.
+type storeChannel struct {
+	store     Store
+	fail      bool
+	mu        sync.Mutex
+	delivered int
+}
+
+func (c *storeChannel) Deliver(rem *Reminder, to string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.store.WithTx(ctx, func(store ReminderStore) error { return nil }); err != nil {
+		return err
+	}
+	if c.fail {
+		return errors.New("delivery failed")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delivered++
+	return nil
+}
+
+func TestSenderQuota(t *testing.T) {
+	store := testSQLiteStore(t)
+	send := func(channels map[string]Channel, n int) []error {
+		deliveries := make(chan *Delivery)
+		quota := &Quota{Defaults: &Limits{MailsPerDay: 2}}
+		sender, errs := NewReminderSender(deliveries, channels, store, quota, 4)
+		go sender.Run()
+		rem := &Reminder{Id: uuid.Must(uuid.NewV4()), Recipient: "alice@example.com", Content: "deploy"}
+		for i := 0; i < n; i++ {
+			deliveries <- &Delivery{rem, rem.Recipient, []string{ChannelEmail, ChannelWebhook}}
+		}
+		close(deliveries)
+		result := make([]error, 0)
+		for err := range errs {
+			result = append(result, err)
+		}
+		return result
+	}
+	count := func() int {
+		var n int
+		err := store.WithTx(context.Background(), func(store ReminderStore) error {
+			var err error
+			n, err = store.CountDeliveriesSince("alice@example.com", time.Now().Add(-time.Hour))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	failing := &storeChannel{store: store, fail: true}
+	if errs := send(map[string]Channel{ChannelEmail: failing}, 2); len(errs) != 2 || count() != 0 {
+		t.Errorf("failed deliveries: got %d errors, %d deliveries recorded", len(errs), count())
+	}
+	channel := &storeChannel{store: store}
+	errs := send(map[string]Channel{ChannelEmail: channel}, 6)
+	var quotaErr *QuotaError
+	for _, err := range errs {
+		if !errors.As(err, &quotaErr) {
+			t.Errorf("got error %v, expected a quota error", err)
+		}
+	}
+	if channel.delivered != 2 || len(errs) != 4 || count() != 2 {
+		t.Errorf("got %d delivered, %d errors, %d deliveries recorded; expected 2, 4, 2", channel.delivered, len(errs), count())
+	}
+
+	// Other channels do not count toward the mail quota.
+	webhook := &storeChannel{store: store}
+	if errs := send(map[string]Channel{ChannelEmail: channel, ChannelWebhook: webhook}, 2); len(errs) != 0 || webhook.delivered != 2 || count() != 2 {
+		t.Errorf("got %v errors, %d delivered by webhook, %d e-mails recorded", errs, webhook.delivered, count())
+	}
+}
+
+// storeSender records mails. Like storeChannel, it times out if it is called
+// in a transaction.
+type storeSender struct {
+	store Store
+	sent  []string
+}
+
+func (s *storeSender) Send(to string, subject string, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.store.WithTx(ctx, func(store ReminderStore) error { return nil }); err != nil {
+		return err
+	}
+	s.sent = append(s.sent, subject)
+	return nil
+}
+
+func TestSaverRejection(t *testing.T) {
+	store := testSQLiteStore(t)
+	sender := &storeSender{store: store}
+	commands := make(chan Command)
+	quota := &Quota{Defaults: &Limits{ActiveReminders: 1}}
+	saver, errs := NewReminderSaver(store, commands, sender, quota, nil)
+	go saver.Run()
+	for _, content := range []string{"deploy", "rollback"} {
+		commands <- &NewReminder{&Reminder{
+			Id:        uuid.Must(uuid.NewV4()),
+			Recipient: "alice@example.com",
+			Content:   content,
+			DueTime:   time.Now().Add(time.Hour),
+		}}
+	}
+	close(commands)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0] != "Reminder not set: rollback" {
+		t.Errorf("got replies %q", sender.sent)
+	}
+}
+
+// quietStore gives users quiet hours in a SQLite store, whose users otherwise
+// all have the default settings.
+type quietStore struct {
+	*SQLiteStore
+	quiet map[string]*QuietHours
+}
+
+type quietTx struct {
+	ReminderStore
+	quiet map[string]*QuietHours
+}
+
+type quietUsers struct {
+	UserStore
+	quiet map[string]*QuietHours
+}
+
+func (s *quietStore) WithTx(ctx context.Context, f func(store ReminderStore) error) error {
+	return s.SQLiteStore.WithTx(ctx, func(store ReminderStore) error {
+		return f(&quietTx{store, s.quiet})
+	})
+}
+
+func (tx *quietTx) Users() UserStore {
+	return &quietUsers{tx.ReminderStore.Users(), tx.quiet}
+}
+
+func (u *quietUsers) QuietHours(email string) (*QuietHours, error) {
+	if quiet, ok := u.quiet[email]; ok {
+		return quiet, nil
+	}
+	return &QuietHours{}, nil
+}
+
+func TestQuerierQuietHours(t *testing.T) {
+	sqliteStore := testSQLiteStore(t).(*SQLiteStore)
+	now := time.Now().UTC()
+	allDay := &QuietHours{
+		Timezone: "UTC",
+		Start:    now.Add(-time.Hour).Format("15:04"),
+		End:      now.Add(time.Hour).Format("15:04"),
+	}
+	store := &quietStore{sqliteStore, map[string]*QuietHours{"bob@example.com": allDay, "carol@example.com": allDay}}
+	rem := &Reminder{
+		Id:            uuid.Must(uuid.NewV4()),
+		Recipient:     "alice@example.com",
+		Recipients:    []string{"bob@example.com"},
+		Content:       "deploy",
+		DueTime:       now.Add(-2 * time.Hour),
+		EscalateTo:    "carol@example.com",
+		EscalateAfter: time.Minute,
+	}
+	err := store.WithTx(context.Background(), func(store ReminderStore) error { return store.Save(rem) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func() []string {
+		querier, reminders, errs := NewDueReminderQuerier(time.Minute, store, time.UTC, nil)
+		go func() {
+			querier.RunOnce()
+			querier.Close()
+		}()
+		deliveries := make([]string, 0)
+		for rem := range reminders {
+			for _, to := range rem.deliveryRecipients() {
+				deliveries = append(deliveries, fmt.Sprintf("%s escalated=%t", to, rem.IsEscalated))
+			}
+		}
+		for err := range errs {
+			t.Error(err)
+		}
+		return deliveries
+	}
+
+	// Bob and the escalation contact are in their quiet hours.
+	if deliveries := query(); strings.Join(deliveries, ", ") != "alice@example.com escalated=false" {
+		t.Errorf("got deliveries %v", deliveries)
+	}
+	if deliveries := query(); len(deliveries) != 0 {
+		t.Errorf("got deliveries %v before the end of the quiet hours", deliveries)
+	}
+	if _, err := sqliteStore.DB.Exec(`UPDATE held_deliveries SET held_until = $1`, now.Unix()); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := query()
+	sort.Strings(deliveries)
+	if strings.Join(deliveries, ", ") != "bob@example.com escalated=false, carol@example.com escalated=true" {
+		t.Errorf("got deliveries %v after the quiet hours", deliveries)
+	}
+}
@@ -0,0 +1,90 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jbchouinard/mxremind/pkg/db"
+)
+
+// ReminderStore is the storage of the mail pipeline, within a transaction.
+type ReminderStore interface {
+	Load(id uuid.UUID) (*Reminder, error)
+	Save(rem *Reminder) error
+	Update(rem *Reminder) error
+	Acknowledge(id uuid.UUID, by string) error
+	Hold(id uuid.UUID, until time.Time) error
+	HoldDelivery(held *HeldDelivery) error
+	ReleaseHeldDeliveries(asOf time.Time) ([]*HeldDelivery, error)
+	AwaitDigest(id uuid.UUID) error
+	QueryDue(asOf time.Time) ([]*Reminder, error)
+	QueryEscalationDue(asOf time.Time) ([]*Reminder, error)
+	CountActive(recipient string) (int, error)
+	CountCreatedSince(recipient string, since time.Time) (int, error)
+	LockRecipient(recipient string) error
+	SaveDelivery(id *uuid.UUID, recipient string, channel string, sentAt time.Time) (int64, error)
+	DeleteDelivery(deliveryId int64) error
+	CountDeliveriesSince(recipient string, since time.Time) (int, error)
+	Users() UserStore
+}
+
+type UserStore interface {
+	Limits(email string, defaults *Limits) (*Limits, error)
+	Channel(email string) (string, error)
+	Digest(email string) (string, error)
+	Routes(email string) ([]*Route, error)
+	QuietHours(email string) (*QuietHours, error)
+}
+
+type Store interface {
+	WithTx(ctx context.Context, f func(store ReminderStore) error) error
+	Close()
+}
+
+var ErrUnsupported = errors.New("not supported by this storage backend")
+
+func OpenStore(ctx context.Context, databaseURL string) (Store, error) {
+	if db.IsSQLite(databaseURL) {
+		sqlDB, err := db.OpenSQLite(ctx, databaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return &SQLiteStore{sqlDB}, nil
+	}
+	pool, err := db.NewPool(ctx, databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStore{pool}, nil
+}
+
+type PostgresStore struct {
+	Pool *pgxpool.Pool
+}
+
+func (s *PostgresStore) WithTx(ctx context.Context, f func(store ReminderStore) error) error {
+	return db.WithTx(ctx, s.Pool, func(tx pgx.Tx) error {
+		return f(&ReminderDAO{Tx: tx, Context: ctx})
+	})
+}
+
+func (s *PostgresStore) Close() {
+	s.Pool.Close()
+}
+
+func (dao *ReminderDAO) Users() UserStore {
+	return &UserDAO{Tx: dao.Tx, Context: dao.Context}
+}
+
+func postgres(store ReminderStore) (*ReminderDAO, error) {
+	dao, ok := store.(*ReminderDAO)
+	if !ok {
+		return nil, fmt.Errorf("%w: requires PostgreSQL", ErrUnsupported)
+	}
+	return dao, nil
+}
@@ -0,0 +1,274 @@
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Zero Limits are unlimited.
+type Limits struct {
+	ActiveReminders  int
+	RemindersPerHour int
+	MailsPerDay      int
+}
+
+type UserDAO struct {
+	Tx      pgx.Tx
+	Context context.Context
+}
+
+func (dao *UserDAO) Limits(email string, defaults *Limits) (*Limits, error) {
+	var limits Limits
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT COALESCE(max_active_reminders, $2),
+				COALESCE(max_reminders_per_hour, $3),
+				COALESCE(max_mails_per_day, $4)
+			FROM users
+			WHERE lower(email) = lower($1)`,
+		email,
+		defaults.ActiveReminders,
+		defaults.RemindersPerHour,
+		defaults.MailsPerDay,
+	).Scan(&limits.ActiveReminders, &limits.RemindersPerHour, &limits.MailsPerDay)
+	if err == pgx.ErrNoRows {
+		return defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+func (dao *UserDAO) Channel(email string) (string, error) {
+	var channel string
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT channel FROM users WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&channel)
+	if err == pgx.ErrNoRows {
+		return ChannelEmail, nil
+	}
+	return channel, err
+}
+
+func (dao *UserDAO) Digest(email string) (string, error) {
+	var digest string
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT digest FROM users WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&digest)
+	if err == pgx.ErrNoRows {
+		return DigestNone, nil
+	}
+	return digest, err
+}
+
+func (dao *UserDAO) Routes(email string) ([]*Route, error) {
+	rows, err := dao.Tx.Query(
+		dao.Context,
+		`SELECT email, match, channels FROM routes WHERE email = lower($1) ORDER BY match`,
+		email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	routes := make([]*Route, 0)
+	for rows.Next() {
+		var route Route
+		if err := rows.Scan(&route.Email, &route.Match, &route.Channels); err != nil {
+			return nil, err
+		}
+		routes = append(routes, &route)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return routes, nil
+}
+
+func (dao *UserDAO) SaveRoute(route *Route) error {
+	_, err := dao.Tx.Exec(
+		dao.Context,
+		`INSERT INTO routes (email, match, channels) VALUES (lower($1), $2, $3)
+			ON CONFLICT (email, match) DO UPDATE SET channels = excluded.channels`,
+		route.Email,
+		route.Match,
+		route.Channels,
+	)
+	return err
+}
+
+func (dao *UserDAO) DeleteRoute(email string, match string) error {
+	tag, err := dao.Tx.Exec(
+		dao.Context,
+		`DELETE FROM routes WHERE email = lower($1) AND match = $2`,
+		email,
+		match,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("no route %q for %q", match, email)
+	}
+	return nil
+}
+
+const (
+	DigestNone   = ""
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Time is a local "HH:MM", on Weekday for weekly digests.
+type DigestSettings struct {
+	Email        string
+	Timezone     string
+	Mode         string
+	Time         string
+	Weekday      time.Weekday
+	LastDigestAt *time.Time
+}
+
+func (dao *UserDAO) DigestSettings() ([]*DigestSettings, error) {
+	rows, err := dao.Tx.Query(
+		dao.Context,
+		`SELECT email, COALESCE(timezone, ''), digest, to_char(digest_time, 'HH24:MI'),
+				digest_weekday, last_digest_at
+			FROM users
+			WHERE digest <> ''`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	settings := make([]*DigestSettings, 0)
+	for rows.Next() {
+		var s DigestSettings
+		var weekday int
+		if err := rows.Scan(&s.Email, &s.Timezone, &s.Mode, &s.Time, &weekday, &s.LastDigestAt); err != nil {
+			return nil, err
+		}
+		s.Weekday = time.Weekday(weekday)
+		settings = append(settings, &s)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return settings, nil
+}
+
+func (dao *UserDAO) SaveDigestSent(email string, at time.Time) error {
+	_, err := dao.Tx.Exec(
+		dao.Context,
+		`UPDATE users SET last_digest_at = $2 WHERE email = $1`,
+		email,
+		at.UTC(),
+	)
+	return err
+}
+
+func (dao *UserDAO) QuietHours(email string) (*QuietHours, error) {
+	var q QuietHours
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT COALESCE(timezone, ''),
+				COALESCE(to_char(quiet_start, 'HH24:MI'), ''),
+				COALESCE(to_char(quiet_end, 'HH24:MI'), ''),
+				quiet_weekends
+			FROM users
+			WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&q.Timezone, &q.Start, &q.End, &q.Weekends)
+	if err == pgx.ErrNoRows {
+		return &QuietHours{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func loadLocation(email string, timezone string, def *time.Location) *time.Location {
+	if timezone == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid timezone of %q", email)
+		return def
+	}
+	return loc
+}
+
+type Preferences struct {
+	Timezone      string
+	Channel       string
+	Digest        string
+	DigestTime    string
+	DigestWeekday time.Weekday
+	QuietStart    string
+	QuietEnd      string
+	QuietWeekends bool
+}
+
+func (dao *UserDAO) Preferences(email string) (*Preferences, error) {
+	p := Preferences{Channel: ChannelEmail, DigestTime: "08:00", DigestWeekday: time.Monday}
+	var weekday int
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT COALESCE(timezone, ''), channel, digest, to_char(digest_time, 'HH24:MI'), digest_weekday,
+				COALESCE(to_char(quiet_start, 'HH24:MI'), ''),
+				COALESCE(to_char(quiet_end, 'HH24:MI'), ''),
+				quiet_weekends
+			FROM users
+			WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&p.Timezone, &p.Channel, &p.Digest, &p.DigestTime, &weekday, &p.QuietStart, &p.QuietEnd, &p.QuietWeekends)
+	if err == pgx.ErrNoRows {
+		return &p, nil
+	}
+	p.DigestWeekday = time.Weekday(weekday)
+	return &p, err
+}
+
+func (dao *UserDAO) SavePreferences(email string, p *Preferences) error {
+	args := []any{
+		email, p.Timezone, p.Channel, p.Digest, p.DigestTime, int(p.DigestWeekday),
+		p.QuietStart, p.QuietEnd, p.QuietWeekends,
+	}
+	tag, err := dao.Tx.Exec(
+		dao.Context,
+		`UPDATE users
+			SET timezone = NULLIF($2, ''),
+				channel = $3,
+				digest = $4,
+				digest_time = $5::time,
+				digest_weekday = $6,
+				quiet_start = NULLIF($7, '')::time,
+				quiet_end = NULLIF($8, '')::time,
+				quiet_weekends = $9
+			WHERE lower(email) = lower($1)`,
+		args...,
+	)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	_, err = dao.Tx.Exec(
+		dao.Context,
+		`INSERT INTO users
+			(email, timezone, channel, digest, digest_time, digest_weekday, quiet_start, quiet_end, quiet_weekends)
+			VALUES
+			(lower($1), NULLIF($2, ''), $3, $4, $5::time, $6, NULLIF($7, '')::time, NULLIF($8, '')::time, $9)`,
+		args...,
+	)
+	return err
+}
@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+)
+
+// Only a hash of feed tokens is stored.
+type Feed struct {
+	Hash      string
+	Email     string
+	CreatedAt time.Time
+}
+
+func (f *Feed) Id() string {
+	return f.Hash[:12]
+}
+
+func FeedPath(token string) string {
+	return "/feeds/" + token + ".ics"
+}
+
+type FeedDAO struct {
+	Tx      pgx.Tx
+	Context context.Context
+}
+
+func (dao *FeedDAO) Create(email string) (string, error) {
+	token, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = dao.Tx.Exec(
+		dao.Context,
+		`INSERT INTO feed_tokens (token_hash, email) VALUES ($1, lower($2))`,
+		hashSecret(token),
+		email,
+	)
+	return token, err
+}
+
+func (dao *FeedDAO) Lookup(token string) (string, error) {
+	var email string
+	err := dao.Tx.QueryRow(
+		dao.Context,
+		`SELECT email FROM feed_tokens WHERE token_hash = $1`,
+		hashSecret(token),
+	).Scan(&email)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
+
+func (dao *FeedDAO) List() ([]*Feed, error) {
+	rows, err := dao.Tx.Query(
+		dao.Context,
+		`SELECT token_hash, email, created_at FROM feed_tokens ORDER BY email, created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	feeds := make([]*Feed, 0)
+	for rows.Next() {
+		var f Feed
+		if err := rows.Scan(&f.Hash, &f.Email, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, &f)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return feeds, nil
+}
+
+func (dao *FeedDAO) Revoke(id string) error {
+	return db.DeleteByHashPrefix(dao.Context, dao.Tx, "feed_tokens", "feed", id)
+}
+
+func FeedCalendar(email string, reminders []*reminder.Reminder, stamp time.Time) []byte {
+	cal := &ical.Calendar{Name: "Reminders of " + email}
+	for _, rem := range reminders {
+		cal.Events = append(cal.Events, rem.Event())
+	}
+	return cal.Write(stamp)
+}
+
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/feeds/"), ".ics")
+	var email string
+	var reminders []*reminder.Reminder
+	err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		var err error
+		email, err = (&FeedDAO{tx, r.Context()}).Lookup(token)
+		if err != nil || email == "" {
+			return err
+		}
+		reminders, err = (&reminder.ReminderDAO{Tx: tx, Context: r.Context()}).QueryPending(email)
+		return err
+	})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if email == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(FeedCalendar(email, reminders, time.Now()))
+}
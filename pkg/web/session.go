@@ -0,0 +1,84 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	LoginTokenLifetime = 15 * time.Minute
+	SessionLifetime    = 30 * 24 * time.Hour
+)
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type SessionDAO struct {
+	Tx      pgx.Tx
+	Context context.Context
+}
+
+func (dao *SessionDAO) CreateLoginToken(email string) (string, error) {
+	return dao.create(`INSERT INTO login_tokens (token_hash, email, expires_at) VALUES ($1, lower($2), $3)`,
+		email, LoginTokenLifetime)
+}
+
+func (dao *SessionDAO) UseLoginToken(token string) (string, error) {
+	return dao.lookup(`DELETE FROM login_tokens WHERE token_hash = $1 AND expires_at > $2 RETURNING email`, token)
+}
+
+func (dao *SessionDAO) CreateSession(email string) (string, error) {
+	return dao.create(`INSERT INTO sessions (session_hash, email, expires_at) VALUES ($1, lower($2), $3)`,
+		email, SessionLifetime)
+}
+
+func (dao *SessionDAO) Session(id string) (string, error) {
+	return dao.lookup(`SELECT email FROM sessions WHERE session_hash = $1 AND expires_at > $2`, id)
+}
+
+func (dao *SessionDAO) DeleteSession(id string) error {
+	_, err := dao.Tx.Exec(dao.Context, `DELETE FROM sessions WHERE session_hash = $1`, hashSecret(id))
+	return err
+}
+
+func (dao *SessionDAO) DeleteExpired() error {
+	now := time.Now().UTC()
+	if _, err := dao.Tx.Exec(dao.Context, `DELETE FROM login_tokens WHERE expires_at <= $1`, now); err != nil {
+		return err
+	}
+	_, err := dao.Tx.Exec(dao.Context, `DELETE FROM sessions WHERE expires_at <= $1`, now)
+	return err
+}
+
+func (dao *SessionDAO) create(sql string, email string, lifetime time.Duration) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = dao.Tx.Exec(dao.Context, sql, hashSecret(secret), email, time.Now().Add(lifetime).UTC())
+	return secret, err
+}
+
+func (dao *SessionDAO) lookup(sql string, secret string) (string, error) {
+	var email string
+	err := dao.Tx.QueryRow(dao.Context, sql, hashSecret(secret), time.Now().UTC()).Scan(&email)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
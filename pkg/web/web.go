@@ -0,0 +1,520 @@
+// Package web implements the web interface for managing reminders.
+package web
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jbchouinard/mxremind/pkg/config"
+	"github.com/jbchouinard/mxremind/pkg/db"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = make(map[string]*template.Template)
+
+func init() {
+	for _, page := range []string{"login", "reminders", "edit", "preferences", "action"} {
+		templates[page] = template.Must(template.ParseFS(templateFS, "templates/layout.html", "templates/"+page+".html"))
+	}
+}
+
+const sessionCookie = "mxremind_session"
+
+const StatusFailed = "failed"
+
+const maxSent = 50
+
+type Server struct {
+	Pool     *pgxpool.Pool
+	Sender   reminder.Sender
+	Quota    *reminder.Quota
+	Senders  *reminder.SenderPolicy
+	Location *time.Location
+	BaseURL  string
+	Channels []string
+	Links    *reminder.ActionLinks
+}
+
+func NewServer(pool *pgxpool.Pool, sender reminder.Sender, conf *config.Config) (*Server, error) {
+	policy, err := reminder.NewPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	channels := []string{reminder.ChannelEmail}
+	if conf.Webhook.URL != "" {
+		channels = append(channels, reminder.ChannelWebhook)
+	}
+	for name := range conf.Channels {
+		channels = append(channels, name)
+	}
+	sort.Strings(channels[1:])
+	return &Server{
+		Pool:     pool,
+		Sender:   sender,
+		Quota:    reminder.NewQuota(conf.Limits),
+		Senders:  policy.Senders,
+		Location: conf.Location(),
+		BaseURL:  conf.HTTP.BaseURL,
+		Channels: channels,
+		Links:    reminder.NewActionLinks(conf.HTTP),
+	}, nil
+}
+
+type page map[string]any
+
+type handler func(w http.ResponseWriter, r *http.Request, email string) error
+
+// h handles the paths that are not part of the web interface.
+func (s *Server) Handler(h http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", h)
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/login/", s.handleLoginLink)
+	mux.HandleFunc("/logout", s.handleLogout)
+	mux.Handle("/reminders", s.authenticated(s.handleReminders))
+	mux.Handle("/reminders/", s.authenticated(s.handleReminder))
+	mux.Handle("/preferences", s.authenticated(s.handlePreferences))
+	mux.HandleFunc("/actions/", s.handleAction)
+	mux.HandleFunc("/feeds/", s.handleFeed)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/reminders", http.StatusSeeOther)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func render(w http.ResponseWriter, status int, name string, data page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates[name].ExecuteTemplate(w, "layout", data); err != nil {
+		log.Warn().Err(err).Msgf("error rendering %s", name)
+	}
+}
+
+func (s *Server) authenticated(h handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email := ""
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+				email, err = (&SessionDAO{tx, r.Context()}).Session(cookie.Value)
+				return err
+			})
+			if err != nil {
+				s.fail(w, r, err)
+				return
+			}
+		}
+		if email == "" {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if err := h(w, r, email); err != nil {
+			s.fail(w, r, err)
+		}
+	})
+}
+
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	log.Error().Err(err).Msgf("%s %s", r.Method, r.URL.Path)
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+// The same page is shown whether or not a link was sent, so that it does not
+// tell who can log in.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		render(w, http.StatusOK, "login", page{})
+		return
+	}
+	address := strings.TrimSpace(r.PostFormValue("email"))
+	if err := s.sendLoginLink(r.Context(), address); err != nil {
+		log.Warn().Err(err).Msgf("login link not sent to %q", address)
+	}
+	render(w, http.StatusOK, "login", page{"Sent": true, "Address": address})
+}
+
+func (s *Server) sendLoginLink(ctx context.Context, address string) error {
+	if !strings.Contains(address, "@") {
+		return errors.New("invalid address")
+	}
+	if err := s.Senders.Check(address); err != nil {
+		return err
+	}
+	var token string
+	var deliveryId int64
+	err := db.WithTx(ctx, s.Pool, func(tx pgx.Tx) error {
+		dao := &reminder.ReminderDAO{Tx: tx, Context: ctx}
+		if err := s.Quota.CheckSend(dao, address); err != nil {
+			return err
+		}
+		var err error
+		token, err = (&SessionDAO{tx, ctx}).CreateLoginToken(address)
+		if err != nil {
+			return err
+		}
+		deliveryId, err = dao.SaveDelivery(nil, address, reminder.ChannelEmail, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(
+		"Open this link to log in to MxRemind:\r\n%s/login/%s\r\n\r\n"+
+			"The link expires in %d minutes. If you did not ask for it, ignore this e-mail.",
+		s.BaseURL, token, int(LoginTokenLifetime.Minutes()),
+	)
+	if err := s.Sender.Send(address, "MxRemind login link", body); err != nil {
+		if err := db.WithTx(ctx, s.Pool, func(tx pgx.Tx) error {
+			return (&reminder.ReminderDAO{Tx: tx, Context: ctx}).DeleteDelivery(deliveryId)
+		}); err != nil {
+			log.Warn().Err(err).Msgf("error deleting failed delivery %d", deliveryId)
+		}
+		return err
+	}
+	return nil
+}
+
+// Opening the link shows a button that posts to it, so that mail scanners do
+// not use up links.
+func (s *Server) handleLoginLink(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/login/")
+	if r.Method != http.MethodPost {
+		render(w, http.StatusOK, "login", page{"Token": token})
+		return
+	}
+	var session string
+	err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		sessions := &SessionDAO{tx, r.Context()}
+		if err := sessions.DeleteExpired(); err != nil {
+			return err
+		}
+		email, err := sessions.UseLoginToken(token)
+		if err != nil || email == "" {
+			return err
+		}
+		session, err = sessions.CreateSession(email)
+		return err
+	})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if session == "" {
+		render(w, http.StatusForbidden, "login", page{"Error": "This login link is invalid or expired."})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(SessionLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.BaseURL, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/reminders", http.StatusSeeOther)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+			return (&SessionDAO{tx, r.Context()}).DeleteSession(cookie.Value)
+		})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// Like login links, opening the link shows a button that posts to it.
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
+	if s.Links == nil {
+		http.NotFound(w, r)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/actions/")
+	now := time.Now()
+	t, err := s.Links.Verify(token, now)
+	switch {
+	case err == reminder.ErrExpiredActionToken:
+		render(w, http.StatusForbidden, "action", page{"Error": "This link has expired."})
+		return
+	case err != nil:
+		render(w, http.StatusForbidden, "action", page{"Error": "This link is invalid."})
+		return
+	}
+	data := page{"Token": token, "Label": reminder.ActionLabel(t.Action)}
+	if r.Method != http.MethodPost {
+		err = db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+			rem, err := (&reminder.ReminderDAO{Tx: tx, Context: r.Context()}).Load(t.ReminderId)
+			if err == pgx.ErrNoRows {
+				return nil
+			}
+			data["Reminder"] = rem
+			return err
+		})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		render(w, http.StatusOK, "action", data)
+		return
+	}
+	err = db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		action := &reminder.LinkAction{Token: t, Now: now, Location: s.Location}
+		return action.Apply(&reminder.ReminderDAO{Tx: tx, Context: r.Context()})
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("action %q on reminder %q failed", t.Action, t.ReminderId)
+		data["Error"] = "This reminder cannot be changed anymore."
+		data["Token"] = ""
+		render(w, http.StatusConflict, "action", data)
+		return
+	}
+	data["Done"] = true
+	render(w, http.StatusOK, "action", data)
+}
+
+type reminderView struct {
+	*reminder.Reminder
+	Status string
+}
+
+type reminderGroup struct {
+	Title     string
+	Reminders []*reminderView
+}
+
+func (s *Server) handleReminders(w http.ResponseWriter, r *http.Request, email string) error {
+	groups := []*reminderGroup{{Title: "Pending"}, {Title: "Failed"}, {Title: "Sent"}}
+	err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		loc, err := s.location(r.Context(), tx, email)
+		if err != nil {
+			return err
+		}
+		dao := &reminder.ReminderDAO{Tx: tx, Context: r.Context()}
+		rems, err := dao.QueryRecipient(email)
+		if err != nil {
+			return err
+		}
+		delivered, err := dao.DeliveredIds(email)
+		if err != nil {
+			return err
+		}
+		for _, rem := range rems {
+			view := &reminderView{rem, rem.Status()}
+			view.DueTime = rem.DueTime.In(loc)
+			if view.Priority == "" {
+				view.Priority = reminder.PriorityNormal
+			}
+			switch {
+			case view.Status == reminder.StatusPending:
+				groups[0].Reminders = append(groups[0].Reminders, view)
+			case view.Status == reminder.StatusSent && !rem.IsDigested && !delivered[rem.Id]:
+				view.Status = StatusFailed
+				groups[1].Reminders = append(groups[1].Reminders, view)
+			case view.Status == reminder.StatusSent:
+				// Most recent first
+				groups[2].Reminders = append([]*reminderView{view}, groups[2].Reminders...)
+			}
+		}
+		if len(groups[2].Reminders) > maxSent {
+			groups[2].Reminders = groups[2].Reminders[:maxSent]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	render(w, http.StatusOK, "reminders", page{"Email": email, "Groups": groups})
+	return nil
+}
+
+func (s *Server) handleReminder(w http.ResponseWriter, r *http.Request, email string) error {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/reminders/"), "/")
+	id, err := uuid.FromString(parts[0])
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "cancel") {
+		http.NotFound(w, r)
+		return nil
+	}
+	cancel := len(parts) == 2
+	if cancel && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	var data page
+	err = db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		loc, err := s.location(r.Context(), tx, email)
+		if err != nil {
+			return err
+		}
+		dao := &reminder.ReminderDAO{Tx: tx, Context: r.Context()}
+		rem, err := dao.Load(id)
+		if err == pgx.ErrNoRows || (err == nil && !strings.EqualFold(rem.Recipient, email)) {
+			return errNotFound
+		} else if err != nil {
+			return err
+		}
+		if cancel {
+			return dao.Cancel(id)
+		}
+		if rem.Status() != reminder.StatusPending {
+			return errNotFound
+		}
+		if r.Method == http.MethodPost {
+			content := strings.TrimSpace(r.PostFormValue("content"))
+			dueTime, err := time.ParseInLocation("2006-01-02T15:04", r.PostFormValue("due_time"), loc)
+			if reminder.CheckContent(content) != nil || err != nil {
+				rem.DueTime = rem.DueTime.In(loc)
+				data = page{"Email": email, "Reminder": rem, "Timezone": loc.String(), "Error": "Invalid reminder or due time."}
+				return nil
+			}
+			rem.Content = content
+			if err := dao.Update(rem); err != nil {
+				return err
+			}
+			return dao.Snooze(rem.Id, dueTime)
+		}
+		rem.DueTime = rem.DueTime.In(loc)
+		data = page{"Email": email, "Reminder": rem, "Timezone": loc.String()}
+		return nil
+	})
+	switch {
+	case err == errNotFound:
+		http.NotFound(w, r)
+	case err != nil:
+		return err
+	case data == nil:
+		http.Redirect(w, r, "/reminders", http.StatusSeeOther)
+	default:
+		status := http.StatusOK
+		if data["Error"] != nil {
+			status = http.StatusBadRequest
+		}
+		render(w, status, "edit", data)
+	}
+	return nil
+}
+
+var errNotFound = errors.New("not found")
+
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+func (s *Server) handlePreferences(w http.ResponseWriter, r *http.Request, email string) error {
+	data := page{
+		"Email":           email,
+		"Channels":        s.Channels,
+		"Weekdays":        weekdays,
+		"DefaultTimezone": s.Location.String(),
+	}
+	status := http.StatusOK
+	err := db.WithTx(r.Context(), s.Pool, func(tx pgx.Tx) error {
+		users := &reminder.UserDAO{Tx: tx, Context: r.Context()}
+		if r.Method != http.MethodPost {
+			prefs, err := users.Preferences(email)
+			data["Preferences"] = prefs
+			return err
+		}
+		prefs, err := s.parsePreferences(r)
+		data["Preferences"] = prefs
+		if err != nil {
+			data["Error"] = err.Error()
+			status = http.StatusBadRequest
+			return nil
+		}
+		data["Saved"] = true
+		return users.SavePreferences(email, prefs)
+	})
+	if err != nil {
+		return err
+	}
+	render(w, status, "preferences", data)
+	return nil
+}
+
+func (s *Server) parsePreferences(r *http.Request) (*reminder.Preferences, error) {
+	weekday, _ := strconv.Atoi(r.PostFormValue("digest_weekday"))
+	prefs := &reminder.Preferences{
+		Timezone:      strings.TrimSpace(r.PostFormValue("timezone")),
+		Channel:       r.PostFormValue("channel"),
+		Digest:        r.PostFormValue("digest"),
+		DigestTime:    r.PostFormValue("digest_time"),
+		DigestWeekday: time.Weekday(weekday),
+		QuietStart:    r.PostFormValue("quiet_start"),
+		QuietEnd:      r.PostFormValue("quiet_end"),
+		QuietWeekends: r.PostFormValue("quiet_weekends") != "",
+	}
+	if prefs.Timezone != "" {
+		if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+			return prefs, fmt.Errorf("Unknown timezone %q.", prefs.Timezone)
+		}
+	}
+	known := false
+	for _, channel := range s.Channels {
+		known = known || channel == prefs.Channel
+	}
+	if !known {
+		return prefs, fmt.Errorf("Unknown channel %q.", prefs.Channel)
+	}
+	switch prefs.Digest {
+	case reminder.DigestNone, reminder.DigestDaily, reminder.DigestWeekly:
+	default:
+		return prefs, fmt.Errorf("Unknown digest %q.", prefs.Digest)
+	}
+	if weekday < 0 || weekday > 6 {
+		return prefs, errors.New("Invalid digest day.")
+	}
+	for _, t := range []string{prefs.DigestTime, prefs.QuietStart, prefs.QuietEnd} {
+		if _, err := time.Parse("15:04", t); err != nil && t != "" {
+			return prefs, fmt.Errorf("Invalid time %q.", t)
+		}
+	}
+	if prefs.DigestTime == "" {
+		return prefs, errors.New("The digest time is required.")
+	}
+	if (prefs.QuietStart == "") != (prefs.QuietEnd == "") {
+		return prefs, errors.New("Quiet hours need a start and an end.")
+	}
+	return prefs, nil
+}
+
+func (s *Server) location(ctx context.Context, tx pgx.Tx, email string) (*time.Location, error) {
+	prefs, err := (&reminder.UserDAO{Tx: tx, Context: ctx}).Preferences(email)
+	if err != nil {
+		return nil, err
+	}
+	if prefs.Timezone == "" {
+		return s.Location, nil
+	}
+	loc, err := time.LoadLocation(prefs.Timezone)
+	if err != nil {
+		return s.Location, nil
+	}
+	return loc, nil
+}
@@ -0,0 +1,108 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbchouinard/mxremind/pkg/ical"
+	"github.com/jbchouinard/mxremind/pkg/reminder"
+)
+
+func TestTemplates(t *testing.T) {
+	rem := &reminder.Reminder{Id: uuid.Must(uuid.NewV4()), Content: "call <bob>", DueTime: time.Now(), Priority: "high"}
+	pages := map[string]page{
+		"login":  {"Token": "abc"},
+		"action": {"Token": "abc", "Label": "Cancel", "Reminder": rem},
+		"reminders": {"Email": "alice@example.com", "Groups": []*reminderGroup{
+			{Title: "Pending", Reminders: []*reminderView{{rem, reminder.StatusPending}}},
+		}},
+		"edit": {"Email": "alice@example.com", "Reminder": rem, "Timezone": "UTC"},
+		"preferences": {
+			"Email":       "alice@example.com",
+			"Channels":    []string{"email", "team"},
+			"Weekdays":    weekdays,
+			"Preferences": &reminder.Preferences{Channel: "team", DigestTime: "08:00", DigestWeekday: time.Monday},
+		},
+	}
+	for name, data := range pages {
+		w := httptest.NewRecorder()
+		render(w, http.StatusOK, name, data)
+		body := w.Body.String()
+		if !strings.HasSuffix(body, "</html>") {
+			t.Errorf("%s: incomplete page:\n%s", name, body)
+		}
+		if strings.Contains(body, "<bob>") {
+			t.Errorf("%s: content is not escaped", name)
+		}
+	}
+}
+
+func TestParsePreferences(t *testing.T) {
+	s := &Server{Channels: []string{"email", "team"}}
+	form := url.Values{
+		"timezone":       {"Europe/Paris"},
+		"channel":        {"team"},
+		"digest":         {"weekly"},
+		"digest_time":    {"07:30"},
+		"digest_weekday": {"5"},
+		"quiet_start":    {"22:00"},
+		"quiet_end":      {"07:00"},
+	}
+	parse := func(form url.Values) (*reminder.Preferences, error) {
+		r := httptest.NewRequest(http.MethodPost, "/preferences", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return s.parsePreferences(r)
+	}
+	prefs, err := parse(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.DigestWeekday != time.Friday || prefs.QuietWeekends || prefs.Channel != "team" {
+		t.Errorf("got %+v", prefs)
+	}
+	for key, value := range map[string]string{
+		"timezone": "Mars/Olympus", "channel": "pager", "digest": "hourly", "quiet_end": "",
+	} {
+		invalid := url.Values{}
+		for k, v := range form {
+			invalid[k] = v
+		}
+		invalid.Set(key, value)
+		if _, err := parse(invalid); err == nil {
+			t.Errorf("expected error for %s=%q", key, value)
+		}
+	}
+}
+
+func TestFeedCalendar(t *testing.T) {
+	due := time.Date(2023, 4, 6, 15, 0, 0, 0, time.UTC)
+	reminders := []*reminder.Reminder{
+		{Id: uuid.Must(uuid.NewV4()), Content: "call bob", DueTime: due},
+		{Id: uuid.Must(uuid.NewV4()), Content: "send report", DueTime: due.Add(time.Hour)},
+	}
+	data := FeedCalendar("alice@example.com", reminders, due)
+	if !strings.Contains(string(data), "X-WR-CALNAME:Reminders of alice@example.com\r\n") {
+		t.Errorf("feed has no name:\n%s", data)
+	}
+	events, err := ical.Parse(data, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events", len(events))
+	}
+	for i, event := range events {
+		if event.UID != reminders[i].Id.String()+"@mxremind" || event.Summary != reminders[i].Content ||
+			!event.Start.Equal(reminders[i].DueTime) || len(event.Alarms) != 0 || event.Method != "" {
+			t.Errorf("got %+v", event)
+		}
+	}
+	if FeedPath("abc") != "/feeds/abc.ics" {
+		t.Errorf("got %s", FeedPath("abc"))
+	}
+}
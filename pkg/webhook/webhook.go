@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jbchouinard/mxremind/pkg/config"
+)
+
+const (
+	SignatureHeader = "X-Mxremind-Signature"
+	TimestampHeader = "X-Mxremind-Timestamp"
+)
+
+// If Secret is set, requests are signed with HMAC-SHA256 over
+// "<X-Mxremind-Timestamp>.<body>". 4xx responses are not retried.
+type Client struct {
+	URL     string
+	Secret  string
+	Retries int
+	Backoff time.Duration
+	HTTP    *http.Client
+}
+
+func NewClient(conf *config.WebhookConfig) *Client {
+	return &Client{
+		URL:     conf.URL,
+		Secret:  conf.Secret,
+		Retries: conf.Retries,
+		Backoff: time.Second,
+		HTTP:    &http.Client{Timeout: time.Duration(conf.Timeout) * time.Second},
+	}
+}
+
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+func (c *Client) Post(payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if c.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(TimestampHeader, timestamp)
+		header.Set(SignatureHeader, Sign(c.Secret, timestamp, body))
+	}
+	return c.Send(&Request{http.MethodPost, c.URL, header, body})
+}
+
+func (c *Client) Send(r *Request) error {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.send(r)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send reports whether a failed request can be retried.
+func (c *Client) send(r *Request) (bool, error) {
+	req, err := http.NewRequest(r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range r.Header {
+		req.Header[key] = values
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s %s returned %s", r.Method, r.URL, resp.Status)
+}
@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostSignsAndRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("secret", r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &Client{URL: server.URL, Secret: "secret", Retries: 3, HTTP: server.Client()}
+	if err := client.Post(map[string]string{"content": "do the thing"}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	client.Secret = "wrong"
+	if err := client.Post(map[string]string{"content": "do the thing"}); err == nil {
+		t.Error("expected error for bad signature")
+	}
+	if attempts != 1 {
+		t.Errorf("expected no retry after 4xx, got %d attempts", attempts)
+	}
+}